@@ -0,0 +1,114 @@
+// 处理收到的自动补全交互
+package Processor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 自动补全事件(扩展) 用户在斜杠命令中输入参数时触发
+// 应用端通过send_autocomplete_result并携带flag返回候选
+type OnebotAutocompleteNotice struct {
+	GroupID    int64                  `json:"group_id,omitempty"`
+	NoticeType string                 `json:"notice_type"`
+	PostType   string                 `json:"post_type"`
+	SelfID     int64                  `json:"self_id"`
+	Time       int64                  `json:"time"`
+	UserID     int64                  `json:"user_id"`
+	Flag       string                 `json:"flag"`
+	Command    string                 `json:"command"` // 命令名,子命令以空格分隔
+	Option     string                 `json:"option"`  // 正在输入的参数名
+	Value      string                 `json:"value"`   // 已输入的部分内容
+	Options    map[string]interface{} `json:"options"` // 其他已填写的参数
+	ChannelID  string                 `json:"channel_id,omitempty"`
+	GuildID    string                 `json:"guild_id,omitempty"`
+}
+
+// ProcessAutocomplete 上报自动补全事件并在超时前用应用端返回的候选响应,超时返回空候选
+func (p *Processors) ProcessAutocomplete(data *discordgo.InteractionCreate, s *discordgo.Session) error {
+	author := interactionAuthor(data)
+	if author == nil {
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(author.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	command := data.ApplicationCommandData()
+	notice := OnebotAutocompleteNotice{
+		NoticeType: "autocomplete",
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		Time:       time.Now().Unix(),
+		UserID:     userid64,
+		Flag:       data.ID,
+		Options:    map[string]interface{}{},
+	}
+	path := []string{command.Name}
+	options := command.Options
+	// 进入子命令组和子命令
+	for len(options) == 1 && (options[0].Type == discordgo.ApplicationCommandOptionSubCommand || options[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup) {
+		path = append(path, options[0].Name)
+		options = options[0].Options
+	}
+	notice.Command = strings.Join(path, " ")
+	for _, option := range options {
+		if option.Focused {
+			notice.Option = option.Name
+			if option.Value != nil {
+				notice.Value = fmt.Sprint(option.Value)
+			}
+			continue
+		}
+		notice.Options[option.Name] = option.Value
+	}
+
+	if data.GuildID != "" {
+		notice.GroupID, err = messageGroupID(data.ChannelID, data.GuildID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+		//增强字段
+		if !config.GetNativeOb11() {
+			notice.ChannelID = data.ChannelID
+			notice.GuildID = data.GuildID
+		}
+	}
+
+	result := handlers.WaitAutocompleteResult(notice.Flag)
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	if err := p.BroadcastMessageToAll(structToMap(notice)); err != nil {
+		mylog.Printf("上报自动补全事件失败: %v", err)
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	timer := time.NewTimer(time.Duration(config.GetAutocompleteTimeout()) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case choices = <-result:
+	case <-timer.C:
+		handlers.CancelAutocompleteResult(notice.Flag)
+		mylog.Printf("等待自动补全结果超时: %v", notice.Command)
+	}
+
+	return s.InteractionRespond(data.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
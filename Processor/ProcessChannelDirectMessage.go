@@ -12,11 +12,13 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/echo"
 	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
 // ProcessChannelDirectMessage 处理频道私信消息 这里我们是被动收到
 func (p *Processors) ProcessChannelDirectMessage(data *discordgo.MessageCreate, se *discordgo.Session) error {
+	metrics.AddMessageReceived()
 	// 打印data结构体
 	//PrintStructWithFieldNames(data)
 
@@ -91,6 +93,9 @@ func (p *Processors) ProcessChannelDirectMessage(data *discordgo.MessageCreate,
 			log.Fatalf("Error storing ID: %v", err)
 		}
 		messageID := int(messageID64)
+		//记录消息所在频道和发送者,供delete_msg、get_msg和撤回事件使用
+		idmap.WriteConfigv2(data.ID, "channel_id", data.ChannelID)
+		idmap.WriteConfigv2(data.ID, "user_id", data.Author.ID)
 		//转换at
 		messageText := handlers.RevertTransformedText(data, "guild_private", se, userid64)
 		if messageText == "" {
@@ -102,7 +107,7 @@ func (p *Processors) ProcessChannelDirectMessage(data *discordgo.MessageCreate,
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = handlers.ConvertToSegmentedMessage(data, se)
 		}
 		var IsBindedUserId bool
 		if config.GetHashIDValue() {
@@ -179,6 +184,9 @@ func (p *Processors) ProcessChannelDirectMessage(data *discordgo.MessageCreate,
 				mylog.Printf("Error storing ID: %v", err)
 				return nil
 			}
+			//记录消息所在频道和发送者,供delete_msg、get_msg和撤回事件使用
+			idmap.WriteConfigv2(data.ID, "channel_id", data.ChannelID)
+			idmap.WriteConfigv2(data.ID, "user_id", data.Author.ID)
 			//OnebotChannelMessage
 			onebotMsg := OnebotChannelMessage{
 				ChannelID:   data.ChannelID,
@@ -317,10 +325,13 @@ func (p *Processors) ProcessChannelDirectMessage(data *discordgo.MessageCreate,
 				return nil
 			}
 			messageID := int(messageID64)
+			//记录消息所在频道和发送者,供delete_msg、get_msg和撤回事件使用
+			idmap.WriteConfigv2(data.ID, "channel_id", data.ChannelID)
+			idmap.WriteConfigv2(data.ID, "user_id", data.Author.ID)
 			// 如果在Array模式下, 则处理Message为Segment格式
 			var segmentedMessages interface{} = messageText
 			if config.GetArrayValue() {
-				segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+				segmentedMessages = handlers.ConvertToSegmentedMessage(data, se)
 			}
 			var IsBindedUserId bool
 			if config.GetHashIDValue() {
@@ -0,0 +1,310 @@
+// 处理收到的成员变动事件
+package Processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 群成员增加/减少事件
+type OnebotGroupMemberNotice struct {
+	GroupID    int64  `json:"group_id"`
+	NoticeType string `json:"notice_type"`
+	OperatorID int64  `json:"operator_id"`
+	PostType   string `json:"post_type"`
+	SelfID     int64  `json:"self_id"`
+	SubType    string `json:"sub_type"`
+	Time       int64  `json:"time"`
+	UserID     int64  `json:"user_id"`
+}
+
+// 群禁言事件
+type OnebotGroupBanNotice struct {
+	GroupID    int64  `json:"group_id"`
+	NoticeType string `json:"notice_type"`
+	OperatorID int64  `json:"operator_id"`
+	PostType   string `json:"post_type"`
+	SelfID     int64  `json:"self_id"`
+	SubType    string `json:"sub_type"`
+	Time       int64  `json:"time"`
+	UserID     int64  `json:"user_id"`
+	Duration   int64  `json:"duration"`
+}
+
+// 加群请求事件 对应开启了成员审核的服务器中的待审核成员
+type OnebotGroupRequest struct {
+	Comment     string `json:"comment"`
+	Flag        string `json:"flag"`
+	GroupID     int64  `json:"group_id"`
+	PostType    string `json:"post_type"`
+	RequestType string `json:"request_type"`
+	SelfID      int64  `json:"self_id"`
+	SubType     string `json:"sub_type"`
+	Time        int64  `json:"time"`
+	UserID      int64  `json:"user_id"`
+}
+
+// discord的封禁没有时长,以-1代表永久
+const banForeverDuration = -1
+
+// 审计日志与事件的最大时间差,超过则认为不是同一次操作
+const auditLogMatchWindow = 10 * time.Second
+
+// ProcessGuildMemberAdd 处理成员加入 转换为group_increase
+// 待审核的成员转换为加群请求,通过审核后再上报group_increase
+func (p *Processors) ProcessGuildMemberAdd(data *discordgo.GuildMemberAdd, s *discordgo.Session) error {
+	if data.Member == nil || data.User == nil {
+		return nil
+	}
+	if data.Pending {
+		return p.broadcastGroupAddRequest(s, data.GuildID, data.User.ID)
+	}
+	return p.broadcastGroupIncrease(s, data.GuildID, data.User.ID)
+}
+
+// ProcessGuildMemberUpdate 处理成员审核状态的变化
+func (p *Processors) ProcessGuildMemberUpdate(data *discordgo.GuildMemberUpdate, s *discordgo.Session) error {
+	if data.Member == nil || data.User == nil {
+		return nil
+	}
+	flag := handlers.GroupAddRequestFlag(data.GuildID, data.User.ID)
+	switch {
+	case data.Pending && flag == "":
+		// 错过了成员加入事件
+		return p.broadcastGroupAddRequest(s, data.GuildID, data.User.ID)
+	case !data.Pending && flag != "":
+		// 成员完成了审核或被授予了身份组
+		handlers.ClearGroupAddRequest(data.GuildID, data.User.ID)
+		return p.broadcastGroupIncrease(s, data.GuildID, data.User.ID)
+	}
+	return nil
+}
+
+// 构造并上报group_increase事件
+func (p *Processors) broadcastGroupIncrease(s *discordgo.Session, guildID, userID string) error {
+	groupID64, err := guildNoticeGroupID(s, guildID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(userID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	notice := OnebotGroupMemberNotice{
+		GroupID:    groupID64,
+		NoticeType: "group_increase",
+		OperatorID: 0,
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		SubType:    "approve",
+		Time:       time.Now().Unix(),
+		UserID:     userid64,
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
+
+// ProcessGuildMemberRemove 处理成员离开 根据审计日志区分leave和kick
+func (p *Processors) ProcessGuildMemberRemove(data *discordgo.GuildMemberRemove, s *discordgo.Session) error {
+	if data.Member == nil || data.User == nil {
+		return nil
+	}
+	// 待审核的成员离开后 加群请求失效
+	handlers.ClearGroupAddRequest(data.GuildID, data.User.ID)
+	groupID64, err := guildNoticeGroupID(s, data.GuildID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(data.User.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	// 未开启member_audit_log时无法区分踢出,按主动退群上报且operator_id为0
+	subType := "leave"
+	var operatorID64 int64
+	if config.GetMemberAuditLog() {
+		// 主动退群时 operator_id 与 user_id 相同
+		operatorID64 = userid64
+		if operator := findAuditLogOperator(s, data.GuildID, data.User.ID, discordgo.AuditLogActionMemberKick); operator != "" {
+			subType = "kick"
+			operatorID64, err = idmap.StoreIDv2(operator)
+			if err != nil {
+				mylog.Printf("Error storing ID: %v", err)
+			}
+		}
+	}
+	if s.State != nil && s.State.User != nil && data.User.ID == s.State.User.ID {
+		subType = "kick_me"
+	}
+
+	notice := OnebotGroupMemberNotice{
+		GroupID:    groupID64,
+		NoticeType: "group_decrease",
+		OperatorID: operatorID64,
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		SubType:    subType,
+		Time:       time.Now().Unix(),
+		UserID:     userid64,
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
+
+// 构造并上报加群请求事件 flag写入数据库供set_group_add_request使用
+func (p *Processors) broadcastGroupAddRequest(s *discordgo.Session, guildID, userID string) error {
+	groupID64, err := guildNoticeGroupID(s, guildID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(userID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	flag, err := handlers.StoreGroupAddRequest(guildID, userID)
+	if err != nil {
+		mylog.Printf("Error storing request flag: %v", err)
+		return nil
+	}
+
+	request := OnebotGroupRequest{
+		Comment:     "",
+		Flag:        flag,
+		GroupID:     groupID64,
+		PostType:    "request",
+		RequestType: "group",
+		SelfID:      int64(p.Settings.AppID),
+		SubType:     "add",
+		Time:        time.Now().Unix(),
+		UserID:      userid64,
+	}
+
+	//调试
+	PrintStructWithFieldNames(request)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(request))
+}
+
+// ProcessGuildBanAdd 处理成员被封禁 转换为group_ban
+func (p *Processors) ProcessGuildBanAdd(data *discordgo.GuildBanAdd, s *discordgo.Session) error {
+	if data.User == nil {
+		return nil
+	}
+	return p.broadcastGuildBan(s, data.GuildID, data.User.ID, "ban", banForeverDuration, discordgo.AuditLogActionMemberBanAdd)
+}
+
+// ProcessGuildBanRemove 处理成员被解除封禁 转换为group_ban的lift_ban
+func (p *Processors) ProcessGuildBanRemove(data *discordgo.GuildBanRemove, s *discordgo.Session) error {
+	if data.User == nil {
+		return nil
+	}
+	return p.broadcastGuildBan(s, data.GuildID, data.User.ID, "lift_ban", 0, discordgo.AuditLogActionMemberBanRemove)
+}
+
+// 构造并上报group_ban事件
+func (p *Processors) broadcastGuildBan(s *discordgo.Session, guildID, userID, subType string, duration int64, action discordgo.AuditLogAction) error {
+	groupID64, err := guildNoticeGroupID(s, guildID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(userID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	var operatorID64 int64
+	if config.GetMemberAuditLog() {
+		if operator := findAuditLogOperator(s, guildID, userID, action); operator != "" {
+			operatorID64, err = idmap.StoreIDv2(operator)
+			if err != nil {
+				mylog.Printf("Error storing ID: %v", err)
+			}
+		}
+	}
+
+	notice := OnebotGroupBanNotice{
+		GroupID:    groupID64,
+		NoticeType: "group_ban",
+		OperatorID: operatorID64,
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		SubType:    subType,
+		Time:       time.Now().Unix(),
+		UserID:     userid64,
+		Duration:   duration,
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
+
+// 成员事件是guild级别的,选取guild的系统频道作为虚拟群,没有则使用guild_id本身
+// 并写入guild_id和type,使应用端可以直接对该群号调用send_group_msg
+func guildNoticeGroupID(s *discordgo.Session, guildID string) (int64, error) {
+	channelID := guildID
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		guild, err = s.Guild(guildID)
+	}
+	if err == nil && guild.SystemChannelID != "" {
+		channelID = guild.SystemChannelID
+	}
+
+	ChannelID64, err := idmap.StoreIDv2(channelID)
+	if err != nil {
+		return 0, err
+	}
+	//转成int再互转
+	idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", guildID)
+	idmap.WriteConfigv2(channelID, "guild_id", guildID)
+	//储存当前群或频道号的类型
+	idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "type", "guild")
+	return ChannelID64, nil
+}
+
+// 从审计日志中找出对target执行action的管理员,找不到(或无查看审计日志权限)时返回空
+func findAuditLogOperator(s *discordgo.Session, guildID, targetID string, action discordgo.AuditLogAction) string {
+	auditLog, err := s.GuildAuditLog(guildID, "", "", int(action), 5)
+	if err != nil {
+		mylog.Printf("获取审计日志失败: %v", err)
+		return ""
+	}
+	for _, entry := range auditLog.AuditLogEntries {
+		if entry.TargetID != targetID {
+			continue
+		}
+		createdAt, err := discordgo.SnowflakeTimestamp(entry.ID)
+		if err != nil || time.Since(createdAt) > auditLogMatchWindow {
+			continue
+		}
+		return entry.UserID
+	}
+	return ""
+}
@@ -11,11 +11,13 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/echo"
 	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
 // ProcessGuildNormalMessage 处理频道常规消息
 func (p *Processors) ProcessGuildNormalMessage(data *discordgo.MessageCreate, se *discordgo.Session) error {
+	metrics.AddMessageReceived()
 	if !p.Settings.GlobalChannelToGroup {
 		// 将时间字符串转换为时间戳
 		t := data.Timestamp
@@ -42,7 +44,7 @@ func (p *Processors) ProcessGuildNormalMessage(data *discordgo.MessageCreate, se
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = handlers.ConvertToSegmentedMessage(data, se)
 		}
 		// 处理onebot_channel_message逻辑
 		onebotMsg := OnebotChannelMessage{
@@ -101,6 +103,9 @@ func (p *Processors) ProcessGuildNormalMessage(data *discordgo.MessageCreate, se
 		echo.AddMsgType(AppIDString, userid64, "guild")
 		//储存当前群或频道号的类型
 		idmap.WriteConfigv2(data.ChannelID, "type", "guild")
+		//记录消息所在频道和发送者,供delete_msg、get_msg和撤回事件使用
+		idmap.WriteConfigv2(data.ID, "channel_id", data.ChannelID)
+		idmap.WriteConfigv2(data.ID, "user_id", data.Author.ID)
 		//todo 完善频道ob信息
 		//懒message_id池
 		echo.AddLazyMessageId(data.ChannelID, data.ID, time.Now())
@@ -170,10 +175,13 @@ func (p *Processors) ProcessGuildNormalMessage(data *discordgo.MessageCreate, se
 			return nil
 		}
 		messageID := int(messageID64)
+		//记录消息所在频道和发送者,供delete_msg、get_msg和撤回事件使用
+		idmap.WriteConfigv2(data.ID, "channel_id", data.ChannelID)
+		idmap.WriteConfigv2(data.ID, "user_id", data.Author.ID)
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = handlers.ConvertToSegmentedMessage(data, se)
 		}
 		IsBindedUserId := idmap.CheckValue(data.Author.ID, userid64)
 		IsBindedGroupId := idmap.CheckValue(data.ChannelID, ChannelID64)
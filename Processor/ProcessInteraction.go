@@ -0,0 +1,154 @@
+// 处理收到的交互事件
+package Processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// ProcessInteraction 将斜杠命令、模态框提交和组件交互转换为消息上报
+// 交互先延迟响应,应用端回复该消息(reply或echo)时作为交互的回复
+func (p *Processors) ProcessInteraction(data *discordgo.InteractionCreate, s *discordgo.Session) error {
+	var content string
+	switch data.Type {
+	case discordgo.InteractionApplicationCommand:
+		content = applicationCommandText(data.ApplicationCommandData())
+	case discordgo.InteractionModalSubmit:
+		content = modalSubmitText(data.ModalSubmitData())
+	case discordgo.InteractionMessageComponent:
+		content = messageComponentText(data.MessageComponentData())
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		return p.ProcessAutocomplete(data, s)
+	default:
+		return nil
+	}
+
+	author := interactionAuthor(data)
+	if author == nil {
+		return nil
+	}
+
+	// 向 Discord 发送确认响应，显示"正在思考"
+	err := s.InteractionRespond(data.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		mylog.Printf("响应交互失败: %v", err)
+		return err
+	}
+	handlers.StorePendingInteraction(data.Interaction)
+
+	mylog.Printf("interactionCreateHandler in channel: %s", data.ChannelID)
+
+	// 交互没有对应的消息,以交互id作为消息id
+	msg := &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        data.ID,
+			ChannelID: data.ChannelID,
+			GuildID:   data.GuildID,
+			Content:   content,
+			Author:    author,
+			Member:    data.Member,
+			Timestamp: time.Now(),
+		},
+	}
+	if data.GuildID == "" {
+		return p.ProcessChannelDirectMessage(msg, s)
+	}
+	return p.ProcessGuildNormalMessage(msg, s)
+}
+
+// 交互的发起者 私信中的交互没有member
+func interactionAuthor(data *discordgo.InteractionCreate) *discordgo.User {
+	if data.Member != nil {
+		return data.Member.User
+	}
+	return data.User
+}
+
+// 斜杠命令转换为 /命令 子命令 参数=值
+func applicationCommandText(data discordgo.ApplicationCommandInteractionData) string {
+	var sb strings.Builder
+	sb.WriteString("/" + data.Name)
+	writeCommandOptions(&sb, data.Options, data.Resolved)
+	return sb.String()
+}
+
+func writeCommandOptions(sb *strings.Builder, options []*discordgo.ApplicationCommandInteractionDataOption, resolved *discordgo.ApplicationCommandInteractionDataResolved) {
+	for _, option := range options {
+		switch option.Type {
+		case discordgo.ApplicationCommandOptionSubCommand, discordgo.ApplicationCommandOptionSubCommandGroup:
+			sb.WriteString(" " + option.Name)
+			writeCommandOptions(sb, option.Options, resolved)
+		default:
+			sb.WriteString(" " + option.Name + "=" + commandOptionValue(option, resolved))
+		}
+	}
+}
+
+// 用户、身份组、频道转换为discord的提及格式,随后与普通消息一样转换为at
+func commandOptionValue(option *discordgo.ApplicationCommandInteractionDataOption, resolved *discordgo.ApplicationCommandInteractionDataResolved) string {
+	switch option.Type {
+	case discordgo.ApplicationCommandOptionUser:
+		return "<@" + option.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionRole:
+		return "<@&" + option.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionChannel:
+		return "<#" + option.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionMentionable:
+		id := option.StringValue()
+		if resolved != nil {
+			if _, ok := resolved.Roles[id]; ok {
+				return "<@&" + id + ">"
+			}
+		}
+		return "<@" + id + ">"
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(option.IntValue(), 10)
+	case discordgo.ApplicationCommandOptionNumber:
+		return strconv.FormatFloat(option.FloatValue(), 'f', -1, 64)
+	case discordgo.ApplicationCommandOptionBoolean:
+		return strconv.FormatBool(option.BoolValue())
+	case discordgo.ApplicationCommandOptionAttachment:
+		id := fmt.Sprint(option.Value)
+		if resolved != nil {
+			if attachment, ok := resolved.Attachments[id]; ok {
+				return attachment.URL
+			}
+		}
+		return id
+	}
+	return fmt.Sprint(option.Value)
+}
+
+// 模态框提交转换为 custom_id 输入框=值
+func modalSubmitText(data discordgo.ModalSubmitInteractionData) string {
+	var sb strings.Builder
+	sb.WriteString(data.CustomID)
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, item := range row.Components {
+			if input, ok := item.(*discordgo.TextInput); ok {
+				sb.WriteString(" " + input.CustomID + "=" + input.Value)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// 按钮为custom_id,选择菜单在custom_id后附加选中的值
+func messageComponentText(data discordgo.MessageComponentInteractionData) string {
+	if len(data.Values) == 0 {
+		return data.CustomID
+	}
+	return data.CustomID + " " + strings.Join(data.Values, " ")
+}
@@ -0,0 +1,251 @@
+// 处理收到的消息编辑和删除事件
+package Processor
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 撤回事件 群消息为group_recall,私信为friend_recall
+type OnebotRecallNotice struct {
+	GroupID    int64  `json:"group_id,omitempty"`
+	MessageID  int    `json:"message_id"`
+	NoticeType string `json:"notice_type"`
+	OperatorID int64  `json:"operator_id,omitempty"`
+	PostType   string `json:"post_type"`
+	SelfID     int64  `json:"self_id"`
+	Time       int64  `json:"time"`
+	UserID     int64  `json:"user_id"`
+	ChannelID  string `json:"channel_id,omitempty"`
+	GuildID    string `json:"guild_id,omitempty"`
+}
+
+// 消息编辑事件(扩展) 群消息为group_msg_edit,私信为friend_msg_edit
+// message和raw_message为编辑后的内容
+type OnebotMsgEditNotice struct {
+	GroupID    int64       `json:"group_id,omitempty"`
+	MessageID  int         `json:"message_id"`
+	NoticeType string      `json:"notice_type"`
+	PostType   string      `json:"post_type"`
+	SelfID     int64       `json:"self_id"`
+	Time       int64       `json:"time"`
+	UserID     int64       `json:"user_id"`
+	Message    interface{} `json:"message"`
+	RawMessage string      `json:"raw_message"`
+	ChannelID  string      `json:"channel_id,omitempty"`
+	GuildID    string      `json:"guild_id,omitempty"`
+}
+
+// ProcessMessageUpdate 处理消息编辑 转换为扩展的编辑事件
+func (p *Processors) ProcessMessageUpdate(data *discordgo.MessageUpdate, s *discordgo.Session) error {
+	// 链接预览等更新不带作者和编辑时间,不是用户的编辑
+	if data.Message == nil || data.Author == nil || data.EditedTimestamp == nil {
+		return nil
+	}
+	if data.BeforeUpdate != nil && data.BeforeUpdate.Content == data.Content {
+		return nil
+	}
+
+	messageID64, err := idmap.StoreIDv2(data.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(data.Author.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	notice := OnebotMsgEditNotice{
+		MessageID:  int(messageID64),
+		NoticeType: "friend_msg_edit",
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		Time:       data.EditedTimestamp.Unix(),
+		UserID:     userid64,
+	}
+	if data.GuildID != "" {
+		notice.NoticeType = "group_msg_edit"
+		notice.GroupID, err = messageGroupID(data.ChannelID, data.GuildID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+		//增强字段
+		if !config.GetNativeOb11() {
+			notice.ChannelID = data.ChannelID
+			notice.GuildID = data.GuildID
+		}
+	}
+
+	// msgtype留空 被白名单拦截时不会向频道发送兜底回复
+	wrapped := &discordgo.MessageCreate{Message: data.Message}
+	notice.RawMessage = handlers.RevertTransformedText(wrapped, "", s, notice.GroupID)
+	notice.Message = notice.RawMessage
+	if config.GetArrayValue() {
+		notice.Message = handlers.ConvertToSegmentedMessage(wrapped, s)
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
+
+// ProcessMessageDelete 处理消息删除 转换为group_recall或friend_recall
+func (p *Processors) ProcessMessageDelete(data *discordgo.MessageDelete, s *discordgo.Session) error {
+	if data.Message == nil {
+		return nil
+	}
+	authorID := messageAuthorID(data.ID, data.BeforeDelete)
+
+	// 作者自己删除时审计日志没有记录,此时operator即作者
+	operatorID := authorID
+	if data.GuildID != "" && authorID != "" && config.GetRecallAuditLog() {
+		if operator := findMessageDeleteOperator(s, data.GuildID, data.ChannelID, authorID); operator != "" {
+			operatorID = operator
+		}
+	}
+	return p.broadcastRecall(data.GuildID, data.ChannelID, data.ID, authorID, operatorID)
+}
+
+// ProcessMessageDeleteBulk 处理批量删除 每条消息上报一次撤回
+func (p *Processors) ProcessMessageDeleteBulk(data *discordgo.MessageDeleteBulk, s *discordgo.Session) error {
+	var operatorID string
+	if data.GuildID != "" && config.GetRecallAuditLog() {
+		operatorID = findAuditLogOperator(s, data.GuildID, data.ChannelID, discordgo.AuditLogActionMessageBulkDelete)
+	}
+	for _, messageID := range data.Messages {
+		authorID := messageAuthorID(messageID, nil)
+		if err := p.broadcastRecall(data.GuildID, data.ChannelID, messageID, authorID, operatorID); err != nil {
+			mylog.Printf("上报撤回事件失败: %v", err)
+		}
+	}
+	return nil
+}
+
+var (
+	messageDeleteAuditMu sync.Mutex
+	// 每个服务器上次查询到的消息删除审计日志条目 条目id -> options.count
+	messageDeleteAuditCounts = make(map[string]map[string]int)
+)
+
+// 从审计日志中找出删除消息的管理员
+// 同一管理员短时间内在同一子频道删除同一作者的多条消息时,discord只增加已有条目的options.count,
+// 因此匹配子频道,并与上次查询到的count比较,count增加或新出现的条目才是本次删除
+func findMessageDeleteOperator(s *discordgo.Session, guildID, channelID, authorID string) string {
+	auditLog, err := s.GuildAuditLog(guildID, "", "", int(discordgo.AuditLogActionMessageDelete), 10)
+	if err != nil {
+		mylog.Printf("获取审计日志失败: %v", err)
+		return ""
+	}
+
+	messageDeleteAuditMu.Lock()
+	defer messageDeleteAuditMu.Unlock()
+	previous := messageDeleteAuditCounts[guildID]
+	counts := make(map[string]int, len(auditLog.AuditLogEntries))
+	var operatorID string
+	for _, entry := range auditLog.AuditLogEntries {
+		count := 1
+		if entry.Options != nil {
+			if n, err := strconv.Atoi(entry.Options.Count); err == nil {
+				count = n
+			}
+		}
+		counts[entry.ID] = count
+		if operatorID != "" || entry.TargetID != authorID || entry.Options == nil || entry.Options.ChannelID != channelID {
+			continue
+		}
+		if last, ok := previous[entry.ID]; ok {
+			if count > last {
+				operatorID = entry.UserID
+			}
+			continue
+		}
+		createdAt, err := discordgo.SnowflakeTimestamp(entry.ID)
+		if err == nil && time.Since(createdAt) <= auditLogMatchWindow {
+			operatorID = entry.UserID
+		}
+	}
+	messageDeleteAuditCounts[guildID] = counts
+	return operatorID
+}
+
+// 构造并上报撤回事件 作者或操作者未知时为0
+func (p *Processors) broadcastRecall(guildID, channelID, messageID, authorID, operatorID string) error {
+	messageID64, err := idmap.StoreIDv2(messageID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	notice := OnebotRecallNotice{
+		MessageID:  int(messageID64),
+		NoticeType: "friend_recall",
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		Time:       time.Now().Unix(),
+	}
+	if authorID != "" {
+		notice.UserID, err = idmap.StoreIDv2(authorID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+		}
+	}
+	if guildID != "" {
+		notice.NoticeType = "group_recall"
+		notice.GroupID, err = messageGroupID(channelID, guildID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+		if operatorID != "" {
+			notice.OperatorID, err = idmap.StoreIDv2(operatorID)
+			if err != nil {
+				mylog.Printf("Error storing ID: %v", err)
+			}
+		}
+		//增强字段
+		if !config.GetNativeOb11() {
+			notice.ChannelID = channelID
+			notice.GuildID = guildID
+		}
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
+
+// 消息所在子频道对应的群号,并写入guild_id供发送消息使用
+func messageGroupID(channelID, guildID string) (int64, error) {
+	ChannelID64, err := idmap.StoreIDv2(channelID)
+	if err != nil {
+		return 0, err
+	}
+	//转成int再互转
+	idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", guildID)
+	idmap.WriteConfigv2(channelID, "guild_id", guildID)
+	return ChannelID64, nil
+}
+
+// 消息的作者 优先使用state缓存,其次使用收到消息时记录的发送者
+func messageAuthorID(messageID string, cached *discordgo.Message) string {
+	if cached != nil && cached.Author != nil {
+		return cached.Author.ID
+	}
+	authorID, _ := idmap.ReadConfigv2(messageID, "user_id")
+	return authorID
+}
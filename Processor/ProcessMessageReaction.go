@@ -0,0 +1,87 @@
+// 处理收到的表情回应事件
+package Processor
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 表情回应事件 参照Lagrange/NapCat的group_msg_emoji_like
+// 私信中的回应使用friend_msg_emoji_like
+type OnebotMsgEmojiLikeNotice struct {
+	GroupID    int64           `json:"group_id,omitempty"`
+	MessageID  int             `json:"message_id"`
+	NoticeType string          `json:"notice_type"`
+	PostType   string          `json:"post_type"`
+	SelfID     int64           `json:"self_id"`
+	Time       int64           `json:"time"`
+	UserID     int64           `json:"user_id"`
+	Likes      []OnebotMsgLike `json:"likes"`
+	IsAdd      bool            `json:"is_add"`
+	ChannelID  string          `json:"channel_id,omitempty"`
+	GuildID    string          `json:"guild_id,omitempty"`
+}
+
+// 回应的表情 自定义表情的emoji_id为表情id,unicode表情为表情本身
+type OnebotMsgLike struct {
+	EmojiID   string `json:"emoji_id"`
+	EmojiName string `json:"emoji_name,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// ProcessMessageReaction 处理表情回应的添加和移除
+func (p *Processors) ProcessMessageReaction(data *discordgo.MessageReaction, isAdd bool, s *discordgo.Session) error {
+	if data == nil {
+		return nil
+	}
+	userid64, err := idmap.StoreIDv2(data.UserID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+
+	emojiID := data.Emoji.ID
+	if emojiID == "" {
+		emojiID = data.Emoji.Name
+	}
+
+	notice := OnebotMsgEmojiLikeNotice{
+		MessageID:  handlers.StoreMessageID(data.MessageID, data.ChannelID),
+		NoticeType: "friend_msg_emoji_like",
+		PostType:   "notice",
+		SelfID:     int64(p.Settings.AppID),
+		Time:       time.Now().Unix(),
+		UserID:     userid64,
+		Likes: []OnebotMsgLike{{
+			EmojiID:   emojiID,
+			EmojiName: data.Emoji.Name,
+			Count:     1,
+		}},
+		IsAdd: isAdd,
+	}
+
+	if data.GuildID != "" {
+		notice.NoticeType = "group_msg_emoji_like"
+		notice.GroupID, err = messageGroupID(data.ChannelID, data.GuildID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+		//增强字段
+		if !config.GetNativeOb11() {
+			notice.ChannelID = data.ChannelID
+			notice.GuildID = data.GuildID
+		}
+	}
+
+	//调试
+	PrintStructWithFieldNames(notice)
+
+	//上报信息到onebotv11应用端(正反ws)
+	return p.BroadcastMessageToAll(structToMap(notice))
+}
@@ -3,16 +3,20 @@ package Processor
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -22,6 +26,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/echo"
 	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 	"github.com/hoshinonyaruko/gensokyo-discord/wsclient"
 )
@@ -30,9 +35,20 @@ import (
 type Processors struct {
 	Settings        *config.Settings                  // 使用指针
 	Wsclient        []*wsclient.WebSocketClient       // 指针的切片
-	WsServerClients []callapi.WebSocketServerClienter //ws server被连接的客户端
+	WsServerClients []callapi.WebSocketServerClienter //ws server被连接的客户端 通过AddWsServerClient和RemoveWsServerClient修改
+	Session         *discordgo.Session                // 执行反向http返回的快速操作
+
+	wsServerClientsMu sync.RWMutex
 }
 
+// 反向http上报响应体的最大长度
+const maxPostResponseSize = 1 << 20
+
+var (
+	postClient     *http.Client
+	postClientOnce sync.Once
+)
+
 type Sender struct {
 	Nickname string `json:"nickname"`
 	TinyID   string `json:"tiny_id"`
@@ -220,26 +236,57 @@ func structToMap(obj interface{}) map[string]interface{} {
 }
 
 // 修改函数的返回类型为 *Processor
-func NewProcessor(settings *config.Settings, wsclient []*wsclient.WebSocketClient) *Processors {
+func NewProcessor(settings *config.Settings, wsclient []*wsclient.WebSocketClient, s *discordgo.Session) *Processors {
 	return &Processors{
 		Settings: settings,
 		Wsclient: wsclient,
+		Session:  s,
 	}
 }
 
 // 修改函数的返回类型为 *Processor
-func NewProcessorV2(settings *config.Settings) *Processors {
+func NewProcessorV2(settings *config.Settings, s *discordgo.Session) *Processors {
 	return &Processors{
-
 		Settings: settings,
+		Session:  s,
 	}
 }
 
+// AddWsServerClient 登记新连接的正向ws客户端
+func (p *Processors) AddWsServerClient(client callapi.WebSocketServerClienter) {
+	p.wsServerClientsMu.Lock()
+	defer p.wsServerClientsMu.Unlock()
+	p.WsServerClients = append(p.WsServerClients, client)
+}
+
+// RemoveWsServerClient 移除断开的正向ws客户端
+func (p *Processors) RemoveWsServerClient(client callapi.WebSocketServerClienter) {
+	p.wsServerClientsMu.Lock()
+	defer p.wsServerClientsMu.Unlock()
+	for i, wsClient := range p.WsServerClients {
+		if wsClient == client {
+			p.WsServerClients = append(p.WsServerClients[:i], p.WsServerClients[i+1:]...)
+			return
+		}
+	}
+}
+
+// 复制当前的客户端列表,发送时不持有锁
+func (p *Processors) wsServerClients() []callapi.WebSocketServerClienter {
+	p.wsServerClientsMu.RLock()
+	defer p.wsServerClientsMu.RUnlock()
+	return append([]callapi.WebSocketServerClienter(nil), p.WsServerClients...)
+}
+
 // 发信息给所有连接正向ws的客户端
 func (p *Processors) SendMessageToAllClients(message map[string]interface{}) error {
 	var result *multierror.Error
 
-	for _, client := range p.WsServerClients {
+	for _, client := range p.wsServerClients() {
+		// API连接不推送事件
+		if client.Role() == callapi.RoleAPI {
+			continue
+		}
 		// 使用接口的方法
 		err := client.SendMessage(message)
 		if err != nil {
@@ -255,9 +302,14 @@ func (p *Processors) SendMessageToAllClients(message map[string]interface{}) err
 // 方便快捷的发信息函数
 func (p *Processors) BroadcastMessageToAll(message map[string]interface{}) error {
 	var errors []string
+	metrics.AddEventPosted()
 
 	// 发送到我们作为客户端的Wsclient
 	for _, client := range p.Wsclient {
+		// API连接只接受action,不推送事件
+		if client.Role() == callapi.RoleAPI {
+			continue
+		}
 		err := client.SendMessage(message)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("error sending private message via wsclient: %v", err))
@@ -265,23 +317,27 @@ func (p *Processors) BroadcastMessageToAll(message map[string]interface{}) error
 	}
 
 	// 发送到我们作为服务器连接到我们的WsServerClients
-	for _, serverClient := range p.WsServerClients {
+	for _, serverClient := range p.wsServerClients() {
+		// API连接只接受action,不推送事件
+		if serverClient.Role() == callapi.RoleAPI {
+			continue
+		}
 		err := serverClient.SendMessage(message)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("error sending private message via WsServerClient: %v", err))
 		}
 	}
 
+	//判断是否填写了反向post地址
+	if !allEmpty(config.GetPostUrl()) {
+		PostMessageToUrls(message, p.Session)
+	}
+
 	// 在循环结束后处理记录的错误
 	if len(errors) > 0 {
 		return fmt.Errorf(strings.Join(errors, "; "))
 	}
 
-	//判断是否填写了反向post地址
-	if !allEmpty(config.GetPostUrl()) {
-		PostMessageToUrls(message)
-	}
-
 	return nil
 }
 
@@ -295,53 +351,147 @@ func allEmpty(addresses []string) bool {
 	return true
 }
 
-// 上报信息给反向Http
-func PostMessageToUrls(message map[string]interface{}) {
-	// 获取上报 URL 列表
+// 上报信息给反向Http 每个地址独立重试,不阻塞事件处理
+func PostMessageToUrls(message map[string]interface{}, s *discordgo.Session) {
+	// 转换 message 为 JSON 字符串
+	jsonString, err := handlers.ConvertMapToJSONString(message)
+	if err != nil {
+		mylog.Printf("Error converting message to JSON: %v", err)
+		return
+	}
+
 	postUrls := config.GetPostUrl()
+	secrets := config.GetPostSecret()
+	maxRetries := config.GetPostMaxRetries()
+	retriesIntervals := config.GetPostRetriesInterval()
 
-	// 检查 postUrls 是否为空
-	if len(postUrls) > 0 {
+	for i, url := range postUrls {
+		if url == "" {
+			continue
+		}
+		// 未配置的项使用与模板一致的默认值
+		var secret string
+		if i < len(secrets) {
+			secret = secrets[i]
+		}
+		retries := 3
+		if i < len(maxRetries) {
+			retries = maxRetries[i]
+		}
+		interval := 1500
+		if i < len(retriesIntervals) {
+			interval = retriesIntervals[i]
+		}
+		go func(url string, secret string, retries int, interval time.Duration) {
+			body, err := postWithRetries(url, []byte(jsonString), secret, retries, interval)
+			if err != nil {
+				mylog.Printf("Error sending POST request to %s: %v", url, err)
+				return
+			}
+			mylog.Printf("Posted to %s successfully", url)
+			executeQuickOperation(s, message, body)
+		}(url, secret, retries, time.Duration(interval)*time.Millisecond)
+	}
+}
 
-		// 转换 message 为 JSON 字符串
-		jsonString, err := handlers.ConvertMapToJSONString(message)
-		if err != nil {
-			mylog.Printf("Error converting message to JSON: %v", err)
-			return
+// 反向http共用的client 超时取http_timeout,小于5秒时使用5秒
+func getPostClient() *http.Client {
+	postClientOnce.Do(func() {
+		timeout := config.GetHttpTimeOut()
+		if timeout < 5 {
+			timeout = 5
 		}
+		postClient = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	})
+	return postClient
+}
 
-		for _, url := range postUrls {
-			// 创建请求体
-			reqBody := bytes.NewBufferString(jsonString)
+// 发送上报,网络错误或5xx时按间隔重试,返回响应体
+func postWithRetries(url string, body []byte, secret string, retries int, interval time.Duration) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			mylog.Printf("Retrying POST request to %s (%d/%d): %v", url, attempt, retries, lastErr)
+			time.Sleep(interval)
+		}
+		respBody, retryable, err := postOnce(url, body, secret)
+		if err == nil {
+			return respBody, nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+	return nil, lastErr
+}
 
-			// 创建 POST 请求
-			req, err := http.NewRequest("POST", url, reqBody)
-			if err != nil {
-				mylog.Printf("Error creating POST request to %s: %v", url, err)
-				continue
-			}
+func postOnce(url string, body []byte, secret string) ([]byte, bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
 
-			// 设置请求头
-			req.Header.Set("Content-Type", "application/json")
-			// 设置 X-Self-ID
-			selfid := config.GetAppIDStr()
-			req.Header.Set("X-Self-ID", selfid)
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Self-ID", config.GetAppIDStr())
+	if secret != "" {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	}
 
-			// 发送请求
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				mylog.Printf("Error sending POST request to %s: %v", url, err)
-				continue
-			}
+	resp, err := getPostClient().Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
 
-			// 处理响应
-			defer resp.Body.Close()
-			// 可以添加更多的响应处理逻辑，如检查状态码等
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxPostResponseSize))
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return respBody, false, nil
+}
 
-			mylog.Printf("Posted to %s successfully", url)
-		}
+// 上报的响应体为快速操作时,通过.handle_quick_operation执行
+func executeQuickOperation(s *discordgo.Session, event map[string]interface{}, body []byte) {
+	var operation map[string]interface{}
+	if len(bytes.TrimSpace(body)) == 0 || json.Unmarshal(body, &operation) != nil || len(operation) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"action": ".handle_quick_operation",
+		"params": map[string]interface{}{
+			"context":   event,
+			"operation": operation,
+		},
+	})
+	if err != nil {
+		mylog.Printf("Error marshaling quick operation: %v", err)
+		return
+	}
+	var action callapi.ActionMessage
+	if err := json.Unmarshal(data, &action); err != nil {
+		mylog.Printf("快速操作格式错误: %v", err)
+		return
 	}
+	mylog.Printf("执行反向http返回的快速操作: %s", body)
+	callapi.CallAPIFromDict(&quickOperationClient{}, s, action)
+}
+
+// 快速操作没有调用方,不需要回执
+type quickOperationClient struct{}
+
+func (c *quickOperationClient) SendMessage(message map[string]interface{}) error {
+	return nil
 }
 
 func (p *Processors) HandleFrameworkCommand(messageText string, data interface{}, Type string, s *discordgo.Session) error {
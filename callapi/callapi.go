@@ -2,12 +2,32 @@ package callapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
+// onebotv11 响应的retcode
+const (
+	RetCodeOK              = 0
+	RetCodeAsync           = 1
+	RetCodeUpstreamFailed  = 100 // discord api 返回了错误
+	RetCodeInternalError   = 102 // 请求discord失败或框架内部错误
+	RetCodeBadRequest      = 1400
+	RetCodeUnauthorized    = 1401
+	RetCodeForbidden       = 1403
+	RetCodeNotFound        = 1404
+	RetCodeTooManyRequests = 1429
+)
+
+// ErrInvalidParams 参数缺失或无效,handler可用%w包装后返回,将得到1400
+var ErrInvalidParams = errors.New("invalid params")
+
 // onebot发来的action调用信息
 type ActionMessage struct {
 	Action      string        `json:"action"`
@@ -75,39 +95,70 @@ type ParamsContent struct {
 	Message   interface{} `json:"message"`            // 这里使用interface{}因为它可能是多种类型
 	Messages  interface{} `json:"messages,omitempty"` // 坑爹转发信息
 	UserID    interface{} `json:"user_id"`            // 这里使用interface{}因为它可能是多种类型
-	Duration  int         `json:"duration,omitempty"` // 可选的整数
-	Enable    bool        `json:"enable,omitempty"`   // 可选的布尔值
+	MessageID interface{} `json:"message_id"`         // delete_msg get_msg等使用
+	Duration  *int        `json:"duration,omitempty"` // 禁言时长 单位秒 未指定时为30分钟
+	Enable    *bool       `json:"enable,omitempty"`   // 全员禁言开关 未指定时为开启
+	// 群管理
+	RejectAddRequest bool   `json:"reject_add_request,omitempty"` // 踢出后拒绝再次加入
+	Card             string `json:"card,omitempty"`               // 群名片
+	GroupName        string `json:"group_name,omitempty"`         // 群名
+	// 表情回应
+	EmojiID interface{} `json:"emoji_id,omitempty"` // 表情id或unicode表情
+	Set     *bool       `json:"set,omitempty"`      // false时取消回应
+	// 请求处理
+	Flag    string `json:"flag,omitempty"`     // 请求的flag
+	SubType string `json:"sub_type,omitempty"` // 请求类型
+	Approve *bool  `json:"approve,omitempty"`  // 是否同意 默认同意
+	Reason  string `json:"reason,omitempty"`   // 拒绝理由
+	Remark  string `json:"remark,omitempty"`   // 好友备注
+	// 斜杠命令
+	Commands []config.ApplicationCommand `json:"commands,omitempty"` // 要注册的命令列表
+	Choices  []config.CommandChoice      `json:"choices,omitempty"`  // 自动补全的候选
 	// handle quick operation
 	Context   Context   `json:"context"`   // context 字段
 	Operation Operation `json:"operation"` // operation 字段
 }
 
-// Context 结构体用于存储 context 字段相关信息
+// Context 结构体用于存储 context 字段相关信息,即上报的事件本身
+// id在不同消息类型中可能是数字或字符串
 type Context struct {
-	Avatar      string `json:"avatar,omitempty"`       // 用户头像链接
-	Font        int    `json:"font,omitempty"`         // 字体（假设是整数类型）
-	MessageID   int    `json:"message_id,omitempty"`   // 消息 ID
-	MessageSeq  int    `json:"message_seq,omitempty"`  // 消息序列号
-	MessageType string `json:"message_type,omitempty"` // 消息类型
-	PostType    string `json:"post_type,omitempty"`    // 帖子类型
-	SubType     string `json:"sub_type,omitempty"`     // 子类型
-	Time        int64  `json:"time,omitempty"`         // 时间戳
-	UserID      int    `json:"user_id,omitempty"`      // 用户 ID
-	GroupID     int    `json:"group_id,omitempty"`     // 群号
+	Avatar      string      `json:"avatar,omitempty"`       // 用户头像链接
+	Font        int         `json:"font,omitempty"`         // 字体（假设是整数类型）
+	MessageID   interface{} `json:"message_id,omitempty"`   // 消息 ID
+	MessageSeq  int         `json:"message_seq,omitempty"`  // 消息序列号
+	MessageType string      `json:"message_type,omitempty"` // 消息类型 group private guild
+	PostType    string      `json:"post_type,omitempty"`    // 帖子类型
+	SubType     string      `json:"sub_type,omitempty"`     // 子类型
+	Time        int64       `json:"time,omitempty"`         // 时间戳
+	UserID      interface{} `json:"user_id,omitempty"`      // 用户 ID
+	GroupID     interface{} `json:"group_id,omitempty"`     // 群号
+	ChannelID   string      `json:"channel_id,omitempty"`   // 频道消息的子频道id
+	GuildID     string      `json:"guild_id,omitempty"`     // 频道消息的频道id
+	RequestType string      `json:"request_type,omitempty"` // 请求类型 group friend
+	Flag        string      `json:"flag,omitempty"`         // 请求的flag
 }
 
 // Operation 结构体用于存储 operation 字段相关信息
 type Operation struct {
-	Reply    string `json:"reply,omitempty"`     // 回复内容
-	AtSender bool   `json:"at_sender,omitempty"` // 是否 @ 发送者
+	Reply       interface{} `json:"reply,omitempty"`        // 回复内容 字符串或消息段
+	AutoEscape  bool        `json:"auto_escape,omitempty"`  // 回复内容作为纯文本发送
+	AtSender    *bool       `json:"at_sender,omitempty"`    // 是否 @ 发送者 群聊默认为true
+	Delete      bool        `json:"delete,omitempty"`       // 撤回该消息
+	Kick        bool        `json:"kick,omitempty"`         // 踢出发送者
+	Ban         bool        `json:"ban,omitempty"`          // 禁言发送者
+	BanDuration int         `json:"ban_duration,omitempty"` // 禁言时长 单位秒 默认30分钟
+	Approve     *bool       `json:"approve,omitempty"`      // 是否同意请求
+	Remark      string      `json:"remark,omitempty"`       // 好友备注
+	Reason      string      `json:"reason,omitempty"`       // 拒绝理由
 }
 
 // 自定义一个ParamsContent的UnmarshalJSON 让GroupID同时兼容str和int
 func (p *ParamsContent) UnmarshalJSON(data []byte) error {
 	type Alias ParamsContent
 	aux := &struct {
-		GroupID interface{} `json:"group_id"`
-		UserID  interface{} `json:"user_id"`
+		GroupID   interface{} `json:"group_id"`
+		UserID    interface{} `json:"user_id"`
+		MessageID interface{} `json:"message_id"`
 		*Alias
 	}{
 		Alias: (*Alias)(p),
@@ -138,6 +189,17 @@ func (p *ParamsContent) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("UserID has unsupported type")
 	}
 
+	switch v := aux.MessageID.(type) {
+	case nil: // 当MessageID不存在时
+		p.MessageID = ""
+	case float64: // JSON的数字默认被解码为float64
+		p.MessageID = fmt.Sprintf("%.0f", v) // 将其转换为字符串，忽略小数点后的部分
+	case string:
+		p.MessageID = v
+	default:
+		return fmt.Errorf("MessageID has unsupported type")
+	}
+
 	return nil
 }
 
@@ -153,10 +215,29 @@ type Client interface {
 	SendMessage(message map[string]interface{}) error
 }
 
+// onebotv11 ws连接的角色 Universal同时收发,API只接受action,Event只推送事件
+const (
+	RoleUniversal = "Universal"
+	RoleAPI       = "API"
+	RoleEvent     = "Event"
+)
+
+// NormalizeClientRole 解析X-Client-Role 无法识别时为Universal
+func NormalizeClientRole(role string) string {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case "api":
+		return RoleAPI
+	case "event":
+		return RoleEvent
+	}
+	return RoleUniversal
+}
+
 // 为了解决processor和server循环依赖设计的接口
 type WebSocketServerClienter interface {
 	SendMessage(message map[string]interface{}) error
 	Close() error
+	Role() string
 }
 
 // 根据action订阅handler处理api
@@ -169,20 +250,81 @@ func RegisterHandler(action string, handler HandlerFunc) {
 	handlers[action] = handler
 }
 
+// GetHandler 获取已注册的handler,供需要组合其他action的handler使用
+func GetHandler(action string) (HandlerFunc, bool) {
+	handler, ok := handlers[action]
+	return handler, ok
+}
+
 // CallAPIFromDict 处理信息 by calling the 对应的 handler.
 func CallAPIFromDict(client Client, s *discordgo.Session, message ActionMessage) string {
+	metrics.AddAPICall()
 	handler, ok := handlers[message.Action]
 	if !ok {
 		mylog.Println("Unsupported action:", message.Action)
-		return ""
+		return SendFailedResponse(client, RetCodeNotFound, "UNSUPPORTED_ACTION", "不支持的action: "+message.Action, message.Echo)
 	}
 
 	jsonString, err := handler(client, s, message)
 	if err != nil {
 		// 处理错误
 		mylog.Println("Error handling action:", message.Action, "Error:", err)
-		return ""
+		retcode, msg, wording := DescribeError(err)
+		return SendFailedResponse(client, retcode, msg, wording, message.Echo)
 	}
 
 	return jsonString
 }
+
+// DescribeError 将错误转换为onebotv11的retcode,msg和wording,discord的错误码与描述会被保留
+func DescribeError(err error) (int, string, string) {
+	if errors.Is(err, ErrInvalidParams) {
+		return RetCodeBadRequest, "BAD_REQUEST", err.Error()
+	}
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) {
+		if restErr.Message != nil {
+			return RetCodeUpstreamFailed, fmt.Sprintf("DISCORD_API_ERROR_%d", restErr.Message.Code), restErr.Message.Message
+		}
+		return RetCodeUpstreamFailed, "DISCORD_API_ERROR", restErr.Response.Status
+	}
+	return RetCodeInternalError, "INTERNAL_ERROR", err.Error()
+}
+
+// BuildFailedResponse 构造status为failed的响应
+func BuildFailedResponse(retcode int, msg string, wording string, echo interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"status":  "failed",
+		"retcode": retcode,
+		"data":    nil,
+		"msg":     msg,
+		"wording": wording,
+		"message": wording,
+		"echo":    echo,
+	}
+}
+
+// SendFailedResponse 构造失败响应并发送给client,返回json文本
+// 开启always_ok_response时改为发送status=ok retcode=0的响应,错误描述保留在message中
+func SendFailedResponse(client Client, retcode int, msg string, wording string, echo interface{}) string {
+	response := BuildFailedResponse(retcode, msg, wording, echo)
+	if config.GetAlwaysOkResponse() {
+		response = map[string]interface{}{
+			"status":  "ok",
+			"retcode": RetCodeOK,
+			"data":    nil,
+			"message": wording,
+			"echo":    echo,
+		}
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		mylog.Printf("Error marshaling response to JSON: %v", err)
+		return ""
+	}
+	if err := client.SendMessage(response); err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	}
+	mylog.Printf("发送失败回执: %s", string(jsonResponse))
+	return string(jsonResponse)
+}
@@ -27,83 +27,126 @@ type Config struct {
 }
 
 type Settings struct {
-	WsAddress              []string `yaml:"ws_address"`
-	AppID                  uint64   `yaml:"app_id"`
-	Token                  string   `yaml:"token"`
-	ClientSecret           string   `yaml:"client_secret"`
-	TextIntent             []string `yaml:"text_intent"`
-	GlobalChannelToGroup   bool     `yaml:"global_channel_to_group"`
-	GlobalPrivateToChannel bool     `yaml:"global_private_to_channel"`
-	Array                  bool     `yaml:"array"`
-	Server_dir             string   `yaml:"server_dir"`
-	Lotus                  bool     `yaml:"lotus"`
-	Port                   string   `yaml:"port"`
-	WsToken                []string `yaml:"ws_token,omitempty"`         // 连接wss时使用,不是wss可留空 一一对应
-	MasterID               []string `yaml:"master_id,omitempty"`        // 如果需要在群权限判断是管理员是,将user_id填入这里,master_id是一个文本数组
-	EnableWsServer         bool     `yaml:"enable_ws_server,omitempty"` //正向ws开关
-	WsServerToken          string   `yaml:"ws_server_token,omitempty"`  //正向ws token
-	IdentifyFile           bool     `yaml:"identify_file"`              // 域名校验文件
-	Crt                    string   `yaml:"crt"`
-	Key                    string   `yaml:"key"`
-	DeveloperLog           bool     `yaml:"developer_log"`
-	Username               string   `yaml:"server_user_name"`
-	Password               string   `yaml:"server_user_password"`
-	ImageLimit             int      `yaml:"image_sizelimit"`
-	RemovePrefix           bool     `yaml:"remove_prefix"`
-	BackupPort             string   `yaml:"backup_port"`
-	DevlopAcDir            string   `yaml:"develop_access_token_dir"`
-	RemoveAt               bool     `yaml:"remove_at"`
-	DevBotid               string   `yaml:"develop_bot_id"`
-	SandBoxMode            bool     `yaml:"sandbox_mode"`
-	Title                  string   `yaml:"title"`
-	HashID                 bool     `yaml:"hash_id"`
-	TwoWayEcho             bool     `yaml:"twoway_echo"`
-	LazyMessageId          bool     `yaml:"lazy_message_id"`
-	WhitePrefixMode        bool     `yaml:"white_prefix_mode"`
-	WhitePrefixs           []string `yaml:"white_prefixs"`
-	BlackPrefixMode        bool     `yaml:"black_prefix_mode"`
-	BlackPrefixs           []string `yaml:"black_prefixs"`
-	VisualPrefixs          []string `yaml:"visual_prefixs"`
-	VisibleIp              bool     `yaml:"visible_ip"`
-	ForwardMsgLimit        int      `yaml:"forward_msg_limit"`
-	DevMessgeID            bool     `yaml:"dev_message_id"`
-	LogLevel               int      `yaml:"log_level"`
-	SaveLogs               bool     `yaml:"save_logs"`
-	BindPrefix             string   `yaml:"bind_prefix"`
-	MePrefix               string   `yaml:"me_prefix"`
-	FrpPort                string   `yaml:"frp_port"`
-	RemoveBotAtGroup       bool     `yaml:"remove_bot_at_group"`
-	ImageLimitB            int      `yaml:"image_limit"`
-	RecordSampleRate       int      `yaml:"record_sampleRate"`
-	RecordBitRate          int      `yaml:"record_bitRate"`
-	NoWhiteResponse        string   `yaml:"No_White_Response"`
-	SendError              bool     `yaml:"send_error"`
-	AddAtGroup             bool     `yaml:"add_at_group"`
-	UrlPicTransfer         bool     `yaml:"url_pic_transfer"`
-	LotusPassword          string   `yaml:"lotus_password"`
-	WsServerPath           string   `yaml:"ws_server_path"`
-	IdmapPro               bool     `yaml:"idmap_pro"`
-	CardAndNick            string   `yaml:"card_nick"`
-	AutoBind               bool     `yaml:"auto_bind"`
-	CustomBotName          string   `yaml:"custom_bot_name"`
-	SendDelay              int      `yaml:"send_delay"`
-	AtoPCount              int      `yaml:"AMsgRetryAsPMsg_Count"`
-	ReconnecTimes          int      `yaml:"reconnect_times"`
-	HeartBeatInterval      int      `yaml:"heart_beat_interval"`
-	LaunchReconectTimes    int      `yaml:"launch_reconnect_times"`
-	UnlockPrefix           string   `yaml:"unlock_prefix"`
-	WhiteBypass            []int64  `yaml:"white_bypass"`
-	TransferUrl            bool     `yaml:"transfer_url"`
-	ProxyAdress            string   `yaml:"proxy_adress"`
-	HttpAddress            string   `yaml:"http_address"`
-	HttpVersion            int      `yaml:"http_version"`
-	HttpTimeOut            int      `yaml:"http_timeout"`
-	PostUrl                []string `yaml:"post_url"`
-	PostSecret             []string `yaml:"post_secret"`
-	PostMaxRetries         []int    `yaml:"post_max_retries"`
-	PostRetriesInterval    []int    `yaml:"post_retries_interval"`
-	NativeOb11             bool     `yaml:"native_ob11"`
-	StringOb11             bool     `yaml:"string_ob11"`
+	WsAddress              []string             `yaml:"ws_address"`
+	AppID                  uint64               `yaml:"app_id"`
+	Token                  string               `yaml:"token"`
+	ClientSecret           string               `yaml:"client_secret"`
+	TextIntent             []string             `yaml:"text_intent"`
+	GlobalChannelToGroup   bool                 `yaml:"global_channel_to_group"`
+	GlobalPrivateToChannel bool                 `yaml:"global_private_to_channel"`
+	Array                  bool                 `yaml:"array"`
+	Server_dir             string               `yaml:"server_dir"`
+	Lotus                  bool                 `yaml:"lotus"`
+	Port                   string               `yaml:"port"`
+	WsToken                []string             `yaml:"ws_token,omitempty"`       // 连接wss时使用,不是wss可留空 一一对应
+	WsRole                 []string             `yaml:"ws_role,omitempty"`        // 反向ws连接的角色 Universal API Event 一一对应
+	WsApiAddress           []string             `yaml:"ws_api_address,omitempty"` // 拆分的反向ws 与ws_event_address按顺序成对
+	WsEventAddress         []string             `yaml:"ws_event_address,omitempty"`
+	WsSplitToken           []string             `yaml:"ws_split_token,omitempty"`   // 拆分的反向ws每一对使用的token
+	WsQueueSize            int                  `yaml:"ws_queue_size"`              // 反向ws断线期间每个连接最多暂存的事件数
+	WsQueueTTL             int                  `yaml:"ws_queue_ttl"`               // 暂存事件的有效期 单位秒
+	WsQueuePersist         bool                 `yaml:"ws_queue_persist"`           // 暂存事件写入数据库,重启后仍会补发
+	MasterID               []string             `yaml:"master_id,omitempty"`        // 如果需要在群权限判断是管理员是,将user_id填入这里,master_id是一个文本数组
+	EnableWsServer         bool                 `yaml:"enable_ws_server,omitempty"` //正向ws开关
+	WsServerToken          string               `yaml:"ws_server_token,omitempty"`  //正向ws token
+	IdentifyFile           bool                 `yaml:"identify_file"`              // 域名校验文件
+	Crt                    string               `yaml:"crt"`
+	Key                    string               `yaml:"key"`
+	DeveloperLog           bool                 `yaml:"developer_log"`
+	Username               string               `yaml:"server_user_name"`
+	Password               string               `yaml:"server_user_password"`
+	ImageLimit             int                  `yaml:"image_sizelimit"`
+	RemovePrefix           bool                 `yaml:"remove_prefix"`
+	BackupPort             string               `yaml:"backup_port"`
+	DevlopAcDir            string               `yaml:"develop_access_token_dir"`
+	RemoveAt               bool                 `yaml:"remove_at"`
+	DevBotid               string               `yaml:"develop_bot_id"`
+	SandBoxMode            bool                 `yaml:"sandbox_mode"`
+	Title                  string               `yaml:"title"`
+	HashID                 bool                 `yaml:"hash_id"`
+	TwoWayEcho             bool                 `yaml:"twoway_echo"`
+	LazyMessageId          bool                 `yaml:"lazy_message_id"`
+	WhitePrefixMode        bool                 `yaml:"white_prefix_mode"`
+	WhitePrefixs           []string             `yaml:"white_prefixs"`
+	BlackPrefixMode        bool                 `yaml:"black_prefix_mode"`
+	BlackPrefixs           []string             `yaml:"black_prefixs"`
+	VisualPrefixs          []string             `yaml:"visual_prefixs"`
+	VisibleIp              bool                 `yaml:"visible_ip"`
+	ForwardMsgLimit        int                  `yaml:"forward_msg_limit"`
+	DevMessgeID            bool                 `yaml:"dev_message_id"`
+	LogLevel               int                  `yaml:"log_level"`
+	SaveLogs               bool                 `yaml:"save_logs"`
+	BindPrefix             string               `yaml:"bind_prefix"`
+	MePrefix               string               `yaml:"me_prefix"`
+	FrpPort                string               `yaml:"frp_port"`
+	RemoveBotAtGroup       bool                 `yaml:"remove_bot_at_group"`
+	ImageLimitB            int                  `yaml:"image_limit"`
+	RecordSampleRate       int                  `yaml:"record_sampleRate"`
+	RecordBitRate          int                  `yaml:"record_bitRate"`
+	NoWhiteResponse        string               `yaml:"No_White_Response"`
+	SendError              bool                 `yaml:"send_error"`
+	AddAtGroup             bool                 `yaml:"add_at_group"`
+	UrlPicTransfer         bool                 `yaml:"url_pic_transfer"`
+	LotusPassword          string               `yaml:"lotus_password"`
+	WsServerPath           string               `yaml:"ws_server_path"`
+	IdmapPro               bool                 `yaml:"idmap_pro"`
+	CardAndNick            string               `yaml:"card_nick"`
+	AutoBind               bool                 `yaml:"auto_bind"`
+	CustomBotName          string               `yaml:"custom_bot_name"`
+	SendDelay              int                  `yaml:"send_delay"`
+	AtoPCount              int                  `yaml:"AMsgRetryAsPMsg_Count"`
+	ReconnecTimes          int                  `yaml:"reconnect_times"`
+	HeartBeatInterval      int                  `yaml:"heart_beat_interval"`
+	LaunchReconectTimes    int                  `yaml:"launch_reconnect_times"`
+	UnlockPrefix           string               `yaml:"unlock_prefix"`
+	WhiteBypass            []int64              `yaml:"white_bypass"`
+	TransferUrl            bool                 `yaml:"transfer_url"`
+	ProxyAdress            string               `yaml:"proxy_adress"`
+	HttpAddress            string               `yaml:"http_address"`
+	HttpAccessToken        string               `yaml:"http_access_token"`
+	HttpRateLimit          int                  `yaml:"http_rate_limit"`
+	HttpVersion            int                  `yaml:"http_version"`
+	HttpTimeOut            int                  `yaml:"http_timeout"`
+	PostUrl                []string             `yaml:"post_url"`
+	PostSecret             []string             `yaml:"post_secret"`
+	PostMaxRetries         []int                `yaml:"post_max_retries"`
+	PostRetriesInterval    []int                `yaml:"post_retries_interval"`
+	NativeOb11             bool                 `yaml:"native_ob11"`
+	StringOb11             bool                 `yaml:"string_ob11"`
+	AlwaysOkResponse       bool                 `yaml:"always_ok_response"`
+	RequestApproveRole     string               `yaml:"request_approve_role"`
+	RecallAuditLog         bool                 `yaml:"recall_audit_log"`
+	MemberAuditLog         bool                 `yaml:"member_audit_log"`
+	Commands               []ApplicationCommand `yaml:"commands"`
+	AutocompleteTimeout    int                  `yaml:"autocomplete_timeout"`
+}
+
+// ApplicationCommand 斜杠命令 guild_id为空时注册为全局命令,否则只在该服务器可用
+// 同时用于set_application_commands和get_application_commands
+type ApplicationCommand struct {
+	ID          string          `yaml:"-" json:"id,omitempty"`
+	Name        string          `yaml:"name" json:"name"`
+	Description string          `yaml:"description" json:"description"`
+	GuildID     string          `yaml:"guild_id,omitempty" json:"guild_id,omitempty"`
+	Options     []CommandOption `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// CommandOption 斜杠命令的参数 type为string integer number boolean user channel role
+// mentionable attachment,或sub_command sub_command_group(此时options为子命令的参数)
+type CommandOption struct {
+	Type         string          `yaml:"type" json:"type"`
+	Name         string          `yaml:"name" json:"name"`
+	Description  string          `yaml:"description" json:"description"`
+	Required     bool            `yaml:"required,omitempty" json:"required,omitempty"`
+	Autocomplete bool            `yaml:"autocomplete,omitempty" json:"autocomplete,omitempty"`
+	Choices      []CommandChoice `yaml:"choices,omitempty" json:"choices,omitempty"`
+	Options      []CommandOption `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// CommandChoice 参数的可选值
+type CommandChoice struct {
+	Name  string      `yaml:"name" json:"name"`
+	Value interface{} `yaml:"value" json:"value"`
 }
 
 // LoadConfig 从文件中加载配置并初始化单例配置
@@ -389,6 +432,101 @@ func GetWsAddress() []string {
 	return nil // 返回nil，如果instance为nil
 }
 
+// ReverseWsConnection 一条反向ws连接
+type ReverseWsConnection struct {
+	Address string
+	Token   string
+	Role    string // Universal API Event
+}
+
+// 获取全部反向ws连接 ws_address按ws_role确定角色,ws_api_address和ws_event_address分别为API和Event连接
+func GetReverseWsConnections() []ReverseWsConnection {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil {
+		return nil
+	}
+	settings := instance.Settings
+	var connections []ReverseWsConnection
+	for i, address := range settings.WsAddress {
+		if address == "" {
+			continue
+		}
+		connection := ReverseWsConnection{Address: address, Role: "Universal"}
+		if i < len(settings.WsToken) {
+			connection.Token = settings.WsToken[i]
+		}
+		if i < len(settings.WsRole) && settings.WsRole[i] != "" {
+			connection.Role = settings.WsRole[i]
+			warnUnknownWsRole(connection.Role)
+		}
+		connections = append(connections, connection)
+	}
+	splitToken := func(i int) string {
+		if i < len(settings.WsSplitToken) {
+			return settings.WsSplitToken[i]
+		}
+		return ""
+	}
+	for i, address := range settings.WsApiAddress {
+		if address != "" {
+			connections = append(connections, ReverseWsConnection{Address: address, Token: splitToken(i), Role: "API"})
+		}
+	}
+	for i, address := range settings.WsEventAddress {
+		if address != "" {
+			connections = append(connections, ReverseWsConnection{Address: address, Token: splitToken(i), Role: "Event"})
+		}
+	}
+	return connections
+}
+
+// 已提示过的无法识别的ws_role 重连时会反复读取配置,每个值只提示一次
+var warnedWsRoles = make(map[string]bool)
+
+// ws_role拼写错误时连接会按Universal处理 调用方需持有mu
+func warnUnknownWsRole(role string) {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case "universal", "api", "event":
+		return
+	}
+	if warnedWsRoles[role] {
+		return
+	}
+	warnedWsRoles[role] = true
+	mylog.Printf("Warning: 无法识别的ws_role %q,该连接将作为Universal连接,可选Universal、API、Event", role)
+}
+
+// 获取反向ws断线期间每个连接最多暂存的事件数
+func GetWsQueueSize() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil || instance.Settings.WsQueueSize <= 0 {
+		return 1000
+	}
+	return instance.Settings.WsQueueSize
+}
+
+// 获取暂存事件的有效期 单位秒
+func GetWsQueueTTL() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil || instance.Settings.WsQueueTTL <= 0 {
+		return 300
+	}
+	return instance.Settings.WsQueueTTL
+}
+
+// 获取是否将暂存事件写入数据库
+func GetWsQueuePersist() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance == nil {
+		return false
+	}
+	return instance.Settings.WsQueuePersist
+}
+
 // 获取gensokyo服务的地址
 func GetServer_dir() string {
 	mu.Lock()
@@ -1092,6 +1230,10 @@ func GetHeartBeatInterval() int {
 		mylog.Println("Warning: instance is nil when trying to HeartBeatInterval value.")
 		return 5
 	}
+	// 未设置时使用模板的默认值
+	if instance.Settings.HeartBeatInterval <= 0 {
+		return 10
+	}
 	return instance.Settings.HeartBeatInterval
 }
 
@@ -1153,6 +1295,30 @@ func GetHttpAddress() string {
 	return instance.Settings.HttpAddress
 }
 
+// 获取正向http的access_token
+func GetHttpAccessToken() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get HTTP access token.")
+		return ""
+	}
+	return instance.Settings.HttpAccessToken
+}
+
+// 获取正向http每个ip每分钟的最大请求数
+func GetHttpRateLimit() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get HTTP rate limit.")
+		return 0
+	}
+	return instance.Settings.HttpRateLimit
+}
+
 // 获取 HTTP 版本
 func GetHttpVersion() int {
 	mu.Lock()
@@ -1248,3 +1414,95 @@ func GetStringOb11() bool {
 	}
 	return instance.Settings.StringOb11
 }
+
+// 获取AlwaysOkResponse的值
+func GetAlwaysOkResponse() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to AlwaysOkResponse value.")
+		return false
+	}
+	return instance.Settings.AlwaysOkResponse
+}
+
+// 获取同意加群请求时授予的身份组id
+func GetRequestApproveRole() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to RequestApproveRole value.")
+		return ""
+	}
+	return instance.Settings.RequestApproveRole
+}
+
+// 获取是否通过审计日志查找撤回消息的管理员
+func GetRecallAuditLog() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to RecallAuditLog value.")
+		return false
+	}
+	return instance.Settings.RecallAuditLog
+}
+
+// 获取是否通过审计日志查找踢出和封禁成员的管理员
+func GetMemberAuditLog() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to MemberAuditLog value.")
+		return false
+	}
+	return instance.Settings.MemberAuditLog
+}
+
+// 获取启动时注册的斜杠命令
+func GetCommands() []ApplicationCommand {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to Commands value.")
+		return nil
+	}
+	return instance.Settings.Commands
+}
+
+// 获取等待应用端返回自动补全结果的时间 单位毫秒
+// discord要求3秒内响应交互,超出时按上限处理
+func GetAutocompleteTimeout() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to AutocompleteTimeout value.")
+		return 2500
+	}
+	timeout := instance.Settings.AutocompleteTimeout
+	if timeout <= 0 {
+		return 2500
+	}
+	if timeout > 2800 {
+		return 2800
+	}
+	return timeout
+}
+
+// 获取GlobalChannelToGroup的值
+func GetGlobalChannelToGroup() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to GlobalChannelToGroup value.")
+		return false
+	}
+	return instance.Settings.GlobalChannelToGroup
+}
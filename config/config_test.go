@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 使用给定的设置调用GetReverseWsConnections
+func reverseWsConnections(t *testing.T, settings Settings) []ReverseWsConnection {
+	t.Helper()
+	saved := instance
+	t.Cleanup(func() { instance = saved })
+	instance = &Config{Settings: settings}
+	return GetReverseWsConnections()
+}
+
+func TestGetReverseWsConnectionsEmpty(t *testing.T) {
+	if got := reverseWsConnections(t, Settings{}); got != nil {
+		t.Errorf("GetReverseWsConnections() = %+v, want nil", got)
+	}
+}
+
+func TestGetReverseWsConnectionsRoles(t *testing.T) {
+	got := reverseWsConnections(t, Settings{
+		WsAddress: []string{"ws://a", "", "ws://b", "ws://c"},
+		WsToken:   []string{"ta"},
+		WsRole:    []string{"API", "", "", "Event"},
+	})
+	// 空地址被跳过,未填写的角色默认为Universal
+	want := []ReverseWsConnection{
+		{Address: "ws://a", Token: "ta", Role: "API"},
+		{Address: "ws://b", Role: "Universal"},
+		{Address: "ws://c", Role: "Event"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetReverseWsConnections() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetReverseWsConnectionsSplit(t *testing.T) {
+	got := reverseWsConnections(t, Settings{
+		WsAddress:      []string{"ws://a"},
+		WsApiAddress:   []string{"ws://api1", "ws://api2"},
+		WsEventAddress: []string{"ws://event1", ""},
+		WsSplitToken:   []string{"t1"},
+	})
+	// 拆分连接的token按下标与api/event地址配对
+	want := []ReverseWsConnection{
+		{Address: "ws://a", Role: "Universal"},
+		{Address: "ws://api1", Token: "t1", Role: "API"},
+		{Address: "ws://api2", Role: "API"},
+		{Address: "ws://event1", Token: "t1", Role: "Event"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetReverseWsConnections() = %+v, want %+v", got, want)
+	}
+}
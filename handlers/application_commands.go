@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 配置和action中使用的参数类型名
+var commandOptionTypes = map[string]discordgo.ApplicationCommandOptionType{
+	"sub_command":       discordgo.ApplicationCommandOptionSubCommand,
+	"sub_command_group": discordgo.ApplicationCommandOptionSubCommandGroup,
+	"string":            discordgo.ApplicationCommandOptionString,
+	"integer":           discordgo.ApplicationCommandOptionInteger,
+	"boolean":           discordgo.ApplicationCommandOptionBoolean,
+	"user":              discordgo.ApplicationCommandOptionUser,
+	"channel":           discordgo.ApplicationCommandOptionChannel,
+	"role":              discordgo.ApplicationCommandOptionRole,
+	"mentionable":       discordgo.ApplicationCommandOptionMentionable,
+	"number":            discordgo.ApplicationCommandOptionNumber,
+	"attachment":        discordgo.ApplicationCommandOptionAttachment,
+}
+
+type ApplicationCommandsResponse struct {
+	Data    []config.ApplicationCommand `json:"data"`
+	Message string                      `json:"message"`
+	RetCode int                         `json:"retcode"`
+	Status  string                      `json:"status"`
+	Echo    interface{}                 `json:"echo"`
+}
+
+func init() {
+	callapi.RegisterHandler("set_application_commands", SetApplicationCommands)
+	callapi.RegisterHandler("get_application_commands", GetApplicationCommands)
+}
+
+// 用commands替换应用设置的斜杠命令,与config中的commands合并后注册,返回注册后的命令
+func SetApplicationCommands(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	if err := storeAppCommands(message.Params.Commands); err != nil {
+		return "", err
+	}
+	commands, err := SyncApplicationCommands(s)
+	if err != nil {
+		mylog.Printf("注册斜杠命令失败: %v", err)
+		return "", err
+	}
+	return sendApplicationCommands(client, message, commands)
+}
+
+// 获取已注册的斜杠命令 指定guild_id时只获取该服务器的命令
+func GetApplicationCommands(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	appID, err := applicationID(s)
+	if err != nil {
+		return "", err
+	}
+	scopes := []string{message.Params.GuildID}
+	if message.Params.GuildID == "" {
+		for _, guildID := range commandScopes() {
+			if guildID != "" {
+				scopes = append(scopes, guildID)
+			}
+		}
+	}
+
+	commands := []config.ApplicationCommand{}
+	for _, guildID := range scopes {
+		registered, err := s.ApplicationCommands(appID, guildID)
+		if err != nil {
+			mylog.Printf("获取斜杠命令失败: %v", err)
+			return "", err
+		}
+		for _, cmd := range registered {
+			commands = append(commands, fromDiscordCommand(cmd))
+		}
+	}
+	return sendApplicationCommands(client, message, commands)
+}
+
+func sendApplicationCommands(client callapi.Client, message callapi.ActionMessage, commands []config.ApplicationCommand) (string, error) {
+	response := ApplicationCommandsResponse{
+		Data:    commands,
+		Message: "",
+		RetCode: 0,
+		Status:  "ok",
+		Echo:    message.Echo,
+	}
+
+	outputMap := structToMap(response)
+
+	mylog.Printf("%v: %+v\n", message.Action, outputMap)
+
+	err := client.SendMessage(outputMap)
+	if err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	}
+	result, err := json.Marshal(response)
+	if err != nil {
+		mylog.Printf("Error marshaling data: %v", err)
+		return "", nil
+	}
+	return string(result), nil
+}
+
+// SyncApplicationCommands 合并config中的commands和应用通过set_application_commands设置的命令,
+// 按作用域(全局或服务器)用ApplicationCommandBulkOverwrite覆盖注册命令
+// 同一作用域下同名的命令以应用设置的为准;曾经注册过命令、这次没有命令的作用域会被清空,
+// 从未注册过命令的作用域(包括全局)不会被改动
+func SyncApplicationCommands(s *discordgo.Session) ([]config.ApplicationCommand, error) {
+	appID, err := applicationID(s)
+	if err != nil {
+		return nil, err
+	}
+
+	byScope := map[string][]*discordgo.ApplicationCommand{}
+	for _, command := range mergeCommands(config.GetCommands(), appCommands()) {
+		cmd, err := toDiscordCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		byScope[command.GuildID] = append(byScope[command.GuildID], cmd)
+	}
+	for _, guildID := range commandScopes() {
+		if _, ok := byScope[guildID]; !ok {
+			byScope[guildID] = []*discordgo.ApplicationCommand{}
+		}
+	}
+
+	// 失败的服务器保留在记录中,下次同步时重试
+	var remaining []string
+	var lastErr error
+	registered := []config.ApplicationCommand{}
+	for guildID, cmds := range byScope {
+		created, err := s.ApplicationCommandBulkOverwrite(appID, guildID, cmds)
+		if err != nil {
+			mylog.Printf("同步斜杠命令失败 guild_id=%q: %v", guildID, err)
+			lastErr = err
+			remaining = append(remaining, guildID)
+			continue
+		}
+		if len(created) > 0 {
+			remaining = append(remaining, guildID)
+		}
+		for _, cmd := range created {
+			registered = append(registered, fromDiscordCommand(cmd))
+		}
+	}
+	storeCommandScopes(remaining)
+	mylog.Printf("已同步%d个斜杠命令", len(registered))
+	return registered, lastErr
+}
+
+func applicationID(s *discordgo.Session) (string, error) {
+	if s == nil || s.State == nil || s.State.User == nil {
+		return "", fmt.Errorf("discord session is not ready")
+	}
+	return s.State.User.ID, nil
+}
+
+// 合并两组命令,同一作用域下同名的命令由override中的替换
+func mergeCommands(base, override []config.ApplicationCommand) []config.ApplicationCommand {
+	overridden := make(map[string]bool, len(override))
+	for _, command := range override {
+		overridden[command.GuildID+"/"+command.Name] = true
+	}
+	merged := make([]config.ApplicationCommand, 0, len(base)+len(override))
+	for _, command := range base {
+		if !overridden[command.GuildID+"/"+command.Name] {
+			merged = append(merged, command)
+		}
+	}
+	return append(merged, override...)
+}
+
+// 应用通过set_application_commands设置的命令 写入数据库,与config中的命令分开保存
+func appCommands() []config.ApplicationCommand {
+	value, _ := idmap.ReadConfigv2("application_commands", "app_commands")
+	if value == "" {
+		return nil
+	}
+	var commands []config.ApplicationCommand
+	if err := json.Unmarshal([]byte(value), &commands); err != nil {
+		mylog.Printf("Error reading app commands: %v", err)
+		return nil
+	}
+	return commands
+}
+
+func storeAppCommands(commands []config.ApplicationCommand) error {
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+	return idmap.WriteConfigv2("application_commands", "app_commands", string(data))
+}
+
+// 记录中全局作用域的写法
+const globalCommandScope = "global"
+
+// 注册过命令的作用域 写入数据库,重启后仍能清空
+func commandScopes() []string {
+	value, _ := idmap.ReadConfigv2("application_commands", "guild_scopes")
+	if value == "" {
+		return nil
+	}
+	scopes := strings.Split(value, ",")
+	for i, scope := range scopes {
+		if scope == globalCommandScope {
+			scopes[i] = ""
+		}
+	}
+	return scopes
+}
+
+func storeCommandScopes(guildIDs []string) {
+	scopes := make([]string, 0, len(guildIDs))
+	for _, guildID := range guildIDs {
+		if guildID == "" {
+			guildID = globalCommandScope
+		}
+		scopes = append(scopes, guildID)
+	}
+	sort.Strings(scopes)
+	if err := idmap.WriteConfigv2("application_commands", "guild_scopes", strings.Join(scopes, ",")); err != nil {
+		mylog.Printf("Error writing config: %v", err)
+	}
+}
+
+func toDiscordCommand(command config.ApplicationCommand) (*discordgo.ApplicationCommand, error) {
+	if command.Name == "" {
+		return nil, fmt.Errorf("%w: command name is empty", callapi.ErrInvalidParams)
+	}
+	options, err := toDiscordOptions(command.Options)
+	if err != nil {
+		return nil, fmt.Errorf("command %v: %w", command.Name, err)
+	}
+	// discord要求命令必须有描述
+	description := command.Description
+	if description == "" {
+		description = command.Name
+	}
+	return &discordgo.ApplicationCommand{
+		Name:        command.Name,
+		Description: description,
+		Options:     options,
+	}, nil
+}
+
+func toDiscordOptions(options []config.CommandOption) ([]*discordgo.ApplicationCommandOption, error) {
+	var converted []*discordgo.ApplicationCommandOption
+	for _, option := range options {
+		optionType, ok := commandOptionTypes[strings.ToLower(option.Type)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown option type %q for %v", callapi.ErrInvalidParams, option.Type, option.Name)
+		}
+		subOptions, err := toDiscordOptions(option.Options)
+		if err != nil {
+			return nil, err
+		}
+		description := option.Description
+		if description == "" {
+			description = option.Name
+		}
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		for _, choice := range option.Choices {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  choice.Name,
+				Value: choice.Value,
+			})
+		}
+		converted = append(converted, &discordgo.ApplicationCommandOption{
+			Type:         optionType,
+			Name:         option.Name,
+			Description:  description,
+			Required:     option.Required,
+			Autocomplete: option.Autocomplete,
+			Choices:      choices,
+			Options:      subOptions,
+		})
+	}
+	return converted, nil
+}
+
+func fromDiscordCommand(cmd *discordgo.ApplicationCommand) config.ApplicationCommand {
+	return config.ApplicationCommand{
+		ID:          cmd.ID,
+		Name:        cmd.Name,
+		Description: cmd.Description,
+		GuildID:     cmd.GuildID,
+		Options:     fromDiscordOptions(cmd.Options),
+	}
+}
+
+func fromDiscordOptions(options []*discordgo.ApplicationCommandOption) []config.CommandOption {
+	var converted []config.CommandOption
+	for _, option := range options {
+		var typeName string
+		for name, optionType := range commandOptionTypes {
+			if optionType == option.Type {
+				typeName = name
+				break
+			}
+		}
+		var choices []config.CommandChoice
+		for _, choice := range option.Choices {
+			choices = append(choices, config.CommandChoice{
+				Name:  choice.Name,
+				Value: choice.Value,
+			})
+		}
+		converted = append(converted, config.CommandOption{
+			Type:         typeName,
+			Name:         option.Name,
+			Description:  option.Description,
+			Required:     option.Required,
+			Autocomplete: option.Autocomplete,
+			Choices:      choices,
+			Options:      fromDiscordOptions(option.Options),
+		})
+	}
+	return converted
+}
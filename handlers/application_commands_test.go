@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+)
+
+func TestMergeCommands(t *testing.T) {
+	base := []config.ApplicationCommand{
+		{Name: "roll", Description: "config"},
+		{Name: "ping", Description: "config"},
+		{Name: "roll", Description: "config", GuildID: "1"},
+	}
+	override := []config.ApplicationCommand{
+		{Name: "roll", Description: "app"},
+		{Name: "help", Description: "app", GuildID: "1"},
+	}
+	// 同一作用域下同名的命令以应用设置的为准,其他作用域不受影响
+	want := []config.ApplicationCommand{
+		{Name: "ping", Description: "config"},
+		{Name: "roll", Description: "config", GuildID: "1"},
+		{Name: "roll", Description: "app"},
+		{Name: "help", Description: "app", GuildID: "1"},
+	}
+	if got := mergeCommands(base, override); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeCommands() = %+v, want %+v", got, want)
+	}
+}
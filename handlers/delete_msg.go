@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("delete_msg", HandleDeleteMsg)
+}
+
+func HandleDeleteMsg(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	messageID, channelID, err := resolveMessageID(message.Params.MessageID)
+	if err != nil {
+		return "", err
+	}
+
+	mylog.Printf("撤回消息 channelID:%v messageID:%v", channelID, messageID)
+	err = s.ChannelMessageDelete(channelID, messageID)
+	if err != nil {
+		mylog.Printf("撤回消息失败: %v", err)
+	}
+
+	// 发送回执
+	retmsg, _ := SendResponse(client, err, &message, 0)
+	return retmsg, nil
+}
+
+// 将onebot的message_id还原为discord的消息id和所在频道
+// 兼容string_ob11等直接上报真实消息id的场景
+func resolveMessageID(rawMessageID interface{}) (string, string, error) {
+	vMessageID, _ := rawMessageID.(string)
+	if vMessageID == "" {
+		return "", "", fmt.Errorf("%w: message_id is empty", callapi.ErrInvalidParams)
+	}
+
+	messageID, err := idmap.RetrieveRowByIDv2(vMessageID)
+	if err != nil {
+		// 找不到映射时 尝试作为真实id使用
+		messageID = vMessageID
+	}
+
+	channelID, err := idmap.ReadConfigv2(messageID, "channel_id")
+	if err != nil || channelID == "" {
+		return "", "", fmt.Errorf("%w: unknown message_id %v", callapi.ErrInvalidParams, vMessageID)
+	}
+	return messageID, channelID, nil
+}
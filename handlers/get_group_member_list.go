@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
@@ -46,91 +49,75 @@ func GetGroupMemberList(client callapi.Client, s *discordgo.Session, message cal
 	msgType, err := idmap.ReadConfigv2(message.Params.GroupID.(string), "type")
 	if err != nil {
 		mylog.Printf("Error reading config: %v", err)
-		return "", nil
+		return "", fmt.Errorf("%w: unknown group_id %v", callapi.ErrInvalidParams, message.Params.GroupID)
 	}
 
 	switch msgType {
 	case "private":
 		mylog.Printf("getGroupMemberList(private): 目前暂未适配私聊虚拟群场景获取虚拟群列表能力")
-		return "", nil
+		return callapi.SendFailedResponse(client, callapi.RetCodeBadRequest, "UNSUPPORTED_GROUP_TYPE", "暂不支持获取私聊虚拟群的成员列表", message.Echo), nil
 	case "guild":
 		//要把group_id还原成guild_id
-		//用group_id还原出channelid 这是虚拟成群的私聊信息
-		message.Params.ChannelID = message.Params.GroupID.(string)
-		// 使用RetrieveRowByIDv2还原真实的ChannelID
-		RChannelID, err := idmap.RetrieveRowByIDv2(message.Params.ChannelID)
+		_, guildID, err := resolveGroupGuild(message.Params.GroupID)
 		if err != nil {
-			mylog.Printf("error retrieving real ChannelID: %v", err)
+			return "", err
 		}
-		//读取ini 通过ChannelID取回之前储存的guild_id
-		value, err := idmap.ReadConfigv2(RChannelID, "guild_id")
+		groupIDInt, err := strconv.ParseInt(message.Params.GroupID.(string), 10, 64)
 		if err != nil {
-			mylog.Printf("Error reading config: %v", err)
-			return "", nil
+			mylog.Printf("Error ParseInt156: %v", err)
 		}
-		// pager := &dto.GuildMembersPager{
-		// 	Limit: "400",
-		// }
-		membersFromAPI, err := s.GuildMembers(value, "", 400)
+
+		guild, err := s.State.Guild(guildID)
 		if err != nil {
-			log.Printf("Failed to fetch group members for guild %s: %v", value, err)
-			return "", nil
+			guild, err = s.Guild(guildID)
+			if err != nil {
+				mylog.Printf("error retrieving guild information: %v", err)
+				return "", err
+			}
 		}
 
-		// mylog.Println("Number of members in membersFromAPI:", len(membersFromAPI))
-		// for i, member := range membersFromAPI {
-		// 	mylog.Printf("Member %d: %+v\n", i+1, *member)
-		// }
+		membersFromAPI, err := fetchGuildMembers(s, guild)
+		if err != nil {
+			log.Printf("Failed to fetch group members for guild %s: %v", guildID, err)
+			return "", err
+		}
 
 		var members []MemberList
 		for _, memberFromAPI := range membersFromAPI {
-			joinedAtTime := memberFromAPI.JoinedAt
-			if err != nil {
-				mylog.Println("Error parsing JoinedAt timestamp:", err)
+			if memberFromAPI.User == nil {
 				continue
 			}
-
-			userIDInt, err := strconv.ParseInt(memberFromAPI.User.ID, 10, 64)
+			// 映射str的userid到int
+			userIDInt, err := idmap.StoreIDv2(memberFromAPI.User.ID)
 			if err != nil {
-				mylog.Printf("Error ParseInt152: %v", err)
+				mylog.Printf("Error storing ID: %v", err)
+				continue
 			}
 
-			groupIDInt, err := strconv.ParseInt(message.Params.GroupID.(string), 10, 64)
-			if err != nil {
-				mylog.Printf("Error ParseInt156: %v", err)
+			nickname := memberFromAPI.User.GlobalName
+			if nickname == "" {
+				nickname = memberFromAPI.User.Username
 			}
+			// 没有服务器昵称时群名片为空
+			card := memberFromAPI.Nick
 
-			joinTimeInt := int32(joinedAtTime.Unix())
 			member := MemberList{
 				UserID:          userIDInt,
 				GroupID:         groupIDInt,
-				Nickname:        memberFromAPI.Nick,
-				Card:            "主人", // 使用默认值
-				Sex:             "0",  // 使用默认值
-				Age:             0,    // 使用默认值
-				Area:            "0",  // 使用默认值
-				JoinTime:        joinTimeInt,
-				LastSentTime:    0,        // 使用默认值
-				Level:           "0",      // 0
-				Role:            "member", //
+				Nickname:        nickname,
+				Card:            card,
+				Sex:             "unknown",
+				Age:             0,
+				Area:            "",
+				JoinTime:        int32(memberFromAPI.JoinedAt.Unix()),
+				LastSentTime:    0,
+				Level:           "0",
+				Role:            memberRole(guild, memberFromAPI, userIDInt),
 				Unfriendly:      false,
-				Title:           "0", // 使用默认值
-				TitleExpireTime: 0,   // 使用默认值
+				Title:           "",
+				TitleExpireTime: 0,
 				CardChangeable:  false,
-				ShutUpTimestamp: 0, // 使用默认值
-			}
-			for _, role := range memberFromAPI.Roles {
-				switch role {
-				case "4":
-					member.Role = "owner"
-				case "2":
-					member.Role = "admin"
-				case "11", "default":
-					member.Role = "member"
-				}
-				if member.Role == "owner" || member.Role == "admin" {
-					break
-				}
+				ShutUpTimestamp: memberShutUpTimestamp(memberFromAPI),
 			}
 			members = append(members, member)
 		}
@@ -153,8 +140,8 @@ func GetGroupMemberList(client callapi.Client, s *discordgo.Session, message cal
 		return string(result), nil
 	default:
 		mylog.Printf("Unknown msgType: %s", msgType)
+		return callapi.SendFailedResponse(client, callapi.RetCodeBadRequest, "UNSUPPORTED_GROUP_TYPE", "不支持的群类型: "+msgType, message.Echo), nil
 	}
-	return "", nil
 }
 
 func buildResponse(members []MemberList, echoValue interface{}) map[string]interface{} {
@@ -208,3 +195,78 @@ func buildResponse(members []MemberList, echoValue interface{}) map[string]inter
 
 	return response
 }
+
+// discord单次最多返回1000个成员
+const guildMembersPageLimit = 1000
+
+// 获取guild的全部成员 state中已有完整成员列表时直接使用,否则按after游标分页拉取并写入state
+func fetchGuildMembers(s *discordgo.Session, guild *discordgo.Guild) ([]*discordgo.Member, error) {
+	if cached, err := s.State.Guild(guild.ID); err == nil {
+		s.State.RLock()
+		members := make([]*discordgo.Member, len(cached.Members))
+		copy(members, cached.Members)
+		s.State.RUnlock()
+		if cached.MemberCount > 0 && len(members) >= cached.MemberCount {
+			return members, nil
+		}
+	}
+
+	var members []*discordgo.Member
+	after := ""
+	for {
+		page, err := s.GuildMembers(guild.ID, after, guildMembersPageLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range page {
+			member.GuildID = guild.ID
+			// guild不在state中时会失败,不影响返回结果
+			_ = s.State.MemberAdd(member)
+		}
+		members = append(members, page...)
+		if len(page) < guildMembersPageLimit {
+			break
+		}
+		after = page[len(page)-1].User.ID
+	}
+	return members, nil
+}
+
+// 根据guild所有者、管理权限和master_id推断onebot的role
+func memberRole(guild *discordgo.Guild, member *discordgo.Member, userID64 int64) string {
+	if member.User.ID == guild.OwnerID {
+		return "owner"
+	}
+	for _, id := range config.GetMasterID() {
+		if strconv.FormatInt(userID64, 10) == id {
+			return "owner"
+		}
+	}
+
+	// 成员权限为@everyone身份组与自身所有身份组权限的并集 @everyone身份组的id与guild_id相同
+	var permissions int64
+	for _, role := range guild.Roles {
+		if role.ID == guild.ID {
+			permissions |= role.Permissions
+			continue
+		}
+		for _, roleID := range member.Roles {
+			if role.ID == roleID {
+				permissions |= role.Permissions
+				break
+			}
+		}
+	}
+	if permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 {
+		return "admin"
+	}
+	return "member"
+}
+
+// 成员处于timeout时返回解除时间戳,否则为0
+func memberShutUpTimestamp(member *discordgo.Member) int64 {
+	if member.CommunicationDisabledUntil == nil || member.CommunicationDisabledUntil.Before(time.Now()) {
+		return 0
+	}
+	return member.CommunicationDisabledUntil.Unix()
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+type GetMsgResponse struct {
+	Data    GetMsgData  `json:"data"`
+	Message string      `json:"message"`
+	RetCode int         `json:"retcode"`
+	Status  string      `json:"status"`
+	Echo    interface{} `json:"echo"`
+}
+
+type GetMsgData struct {
+	Time        int64       `json:"time"`
+	MessageType string      `json:"message_type"`
+	MessageID   int         `json:"message_id"`
+	RealID      string      `json:"real_id"`
+	GroupID     int64       `json:"group_id,omitempty"`
+	Sender      GetMsgUser  `json:"sender"`
+	Message     interface{} `json:"message"`
+	RawMessage  string      `json:"raw_message"`
+}
+
+type GetMsgUser struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Card     string `json:"card"`
+}
+
+func init() {
+	callapi.RegisterHandler("get_msg", HandleGetMsg)
+}
+
+func HandleGetMsg(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	messageID, channelID, err := resolveMessageID(message.Params.MessageID)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		mylog.Printf("获取消息失败: %v", err)
+		return "", err
+	}
+
+	var response GetMsgResponse
+	response.Data = convertToGetMsgData(s, msg)
+	response.Message = ""
+	response.RetCode = 0
+	response.Status = "ok"
+	response.Echo = message.Echo
+
+	outputMap := structToMap(response)
+
+	err = client.SendMessage(outputMap)
+	if err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	} else {
+		mylog.Printf("响应get_msg: %+v", outputMap)
+	}
+	//把结果从struct转换为json
+	result, err := json.Marshal(response)
+	if err != nil {
+		mylog.Printf("Error marshaling data: %v", err)
+		return "", nil
+	}
+	return string(result), nil
+}
+
+// 将从discord拉取的消息转换为onebotv11的get_msg格式
+func convertToGetMsgData(s *discordgo.Session, msg *discordgo.Message) GetMsgData {
+	data := GetMsgData{
+		Time:        msg.Timestamp.Unix(),
+		MessageType: "group",
+		MessageID:   StoreMessageID(msg.ID, msg.ChannelID),
+		RealID:      msg.ID,
+	}
+
+	// 拉取的消息不带guild_id,通过频道类型判断是否私信
+	channel, err := s.State.Channel(msg.ChannelID)
+	if err != nil {
+		channel, err = s.Channel(msg.ChannelID)
+	}
+	if err == nil && (channel.Type == discordgo.ChannelTypeDM || channel.Type == discordgo.ChannelTypeGroupDM) {
+		data.MessageType = "private"
+	} else if !config.GetGlobalChannelToGroup() {
+		data.MessageType = "guild"
+	} else {
+		data.GroupID, err = idmap.StoreIDv2(msg.ChannelID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+		}
+	}
+
+	if msg.Author != nil {
+		data.Sender.UserID, err = idmap.StoreIDv2(msg.Author.ID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+		}
+		data.Sender.Nickname = msg.Author.Username
+	}
+	if msg.Member != nil {
+		data.Sender.Card = msg.Member.Nick
+	}
+
+	// msgtype留空 被白名单拦截时不会向频道发送兜底回复
+	wrapped := &discordgo.MessageCreate{Message: msg}
+	data.RawMessage = RevertTransformedText(wrapped, "", s, data.GroupID)
+	data.Message = data.RawMessage
+	if config.GetArrayValue() {
+		data.Message = ConvertToSegmentedMessage(wrapped, s)
+	}
+	return data
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
@@ -29,12 +31,18 @@ type StatusData struct {
 type Statistics struct {
 	PacketReceived  uint64 `json:"packet_received"`
 	PacketSent      uint64 `json:"packet_sent"`
-	PacketLost      uint32 `json:"packet_lost"`
+	PacketLost      uint64 `json:"packet_lost"`
 	MessageReceived uint64 `json:"message_received"`
 	MessageSent     uint64 `json:"message_sent"`
-	DisconnectTimes uint32 `json:"disconnect_times"`
-	LostTimes       uint32 `json:"lost_times"`
+	DisconnectTimes uint64 `json:"disconnect_times"`
+	LostTimes       uint64 `json:"lost_times"`
 	LastMessageTime int64  `json:"last_message_time"`
+	// 以下为扩展字段
+	APICalls    uint64 `json:"api_calls"`
+	EventPosted uint64 `json:"event_posted"`
+	SendFailed  uint64 `json:"send_failed"`
+	ResumeTimes uint64 `json:"resume_times"`
+	StartTime   int64  `json:"start_time"`
 }
 
 func init() {
@@ -45,24 +53,7 @@ func GetStatus(client callapi.Client, s *discordgo.Session, message callapi.Acti
 
 	var response GetStatusResponse
 
-	response.Data = StatusData{
-		AppInitialized: true,
-		AppEnabled:     true,
-		PluginsGood:    true,
-		AppGood:        true,
-		Online:         true, //测试数据
-		Good:           true, //测试数据
-		Stat: Statistics{
-			PacketReceived:  1000,       //测试数据
-			PacketSent:      950,        //测试数据
-			PacketLost:      50,         //测试数据
-			MessageReceived: 500,        //测试数据
-			MessageSent:     490,        //测试数据
-			DisconnectTimes: 5,          //测试数据
-			LostTimes:       2,          //测试数据
-			LastMessageTime: 1677721600, //测试数据
-		},
-	}
+	response.Data = NewStatusData(s)
 	response.Message = ""
 	response.RetCode = 0
 	response.Status = "ok"
@@ -85,3 +76,53 @@ func GetStatus(client callapi.Client, s *discordgo.Session, message callapi.Acti
 	}
 	return string(result), nil
 }
+
+// NewStatusData 根据网关状态和运行统计生成状态信息
+func NewStatusData(s *discordgo.Session) StatusData {
+	stats := metrics.Snapshot()
+	online := stats.Online && s != nil && sessionReady(s)
+
+	return StatusData{
+		AppInitialized: true,
+		AppEnabled:     true,
+		PluginsGood:    true,
+		AppGood:        true,
+		Online:         online,
+		Good:           online,
+		Stat: Statistics{
+			// 网关收发的数据包以discord消息计,没有丢包统计
+			PacketReceived:  stats.MessageReceived,
+			PacketSent:      stats.MessageSent,
+			PacketLost:      0,
+			MessageReceived: stats.MessageReceived,
+			MessageSent:     stats.MessageSent,
+			DisconnectTimes: stats.Disconnects,
+			LostTimes:       stats.Lost,
+			LastMessageTime: stats.LastMessageTime,
+			APICalls:        stats.APICalls,
+			EventPosted:     stats.EventPosted,
+			SendFailed:      stats.SendFailed,
+			ResumeTimes:     stats.Resumes,
+			StartTime:       stats.StartTime,
+		},
+	}
+}
+
+// DataReady由网关协程在持有锁时修改
+func sessionReady(s *discordgo.Session) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.DataReady
+}
+
+// NewHeartbeatEvent 构造心跳元事件 status与get_status一致,interval为实际的心跳间隔
+func NewHeartbeatEvent(s *discordgo.Session, selfID uint64, interval time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"post_type":       "meta_event",
+		"meta_event_type": "heartbeat",
+		"time":            time.Now().Unix(),
+		"self_id":         selfID,
+		"status":          structToMap(NewStatusData(s)),
+		"interval":        interval.Milliseconds(), // 以毫秒为单位
+	}
+}
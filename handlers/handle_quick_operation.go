@@ -1,53 +1,214 @@
 package handlers
 
 import (
-	"strconv"
+	"fmt"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
 func init() {
 	callapi.RegisterHandler(".handle_quick_operation", Handle_quick_operation)
 }
 
+// 对事件执行快速操作 context为事件本身,operation为要执行的操作
 func Handle_quick_operation(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
-	// 使用 CreateSendGroupMsgAction 函数来确定如何处理消息
-	newMsg := CreateSendGroupMsgAction(message)
-	var retmsg string
-	// 根据返回的 ActionMessage 类型调用相应的处理函数
-	if newMsg != nil {
-		switch newMsg.Action {
-		case "send_group_msg":
-			retmsg, _ = HandleSendGroupMsg(client, s, *newMsg)
-		case "send_private_msg":
-			retmsg, _ = HandleSendPrivateMsg(client, s, *newMsg)
+	ctx := message.Params.Context
+	op := message.Params.Operation
+
+	if ctx.PostType == "request" {
+		if err := quickHandleRequest(s, ctx, op); err != nil {
+			return "", err
+		}
+		retmsg, _ := SendResponse(client, nil, &message, 0)
+		return retmsg, nil
+	}
+
+	var sentID int
+	if op.Reply != nil && op.Reply != "" {
+		var err error
+		sentID, err = quickReply(s, ctx, op)
+		if err != nil {
+			mylog.Printf("快速回复失败: %v", err)
+			return "", err
 		}
 	}
+
+	if op.Delete {
+		messageID, channelID, err := resolveMessageID(segmentValueToString(ctx.MessageID))
+		if err != nil {
+			return "", err
+		}
+		if err := s.ChannelMessageDelete(channelID, messageID); err != nil {
+			mylog.Printf("快速操作撤回消息失败: %v", err)
+			return "", err
+		}
+	}
+
+	// 踢出后无需再禁言
+	if op.Kick || op.Ban {
+		guildID, err := quickOperationGuild(ctx)
+		if err != nil {
+			return "", err
+		}
+		realUserID, err := resolveUserID(ctx.UserID)
+		if err != nil {
+			return "", err
+		}
+		if op.Kick {
+			err = kickMember(s, guildID, realUserID, false)
+		} else {
+			duration := op.BanDuration
+			if duration <= 0 {
+				duration = defaultBanDuration
+			}
+			err = timeoutMember(s, guildID, realUserID, duration)
+		}
+		if err != nil {
+			mylog.Printf("快速操作踢出或禁言失败: %v", err)
+			return "", err
+		}
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, sentID)
 	return retmsg, nil
 }
 
-func CreateSendGroupMsgAction(originalMsg callapi.ActionMessage) *callapi.ActionMessage {
-	switch originalMsg.Params.Context.MessageType {
-	case "group":
-		return &callapi.ActionMessage{
-			Action: "send_group_msg",
-			Params: callapi.ParamsContent{
-				GroupID: strconv.Itoa(originalMsg.Params.Context.GroupID), // 将int转换为string
-				Message: originalMsg.Params.Operation.Reply,
+// 在事件所在的频道回复,返回发出消息的message_id
+func quickReply(s *discordgo.Session, ctx callapi.Context, op callapi.Operation) (int, error) {
+	channelID, err := quickOperationChannel(s, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	reply := op.Reply
+	// 纯文本作为text消息段发送,不解析cq码
+	if text, ok := reply.(string); ok && op.AutoEscape {
+		reply = []interface{}{textSegment(text)}
+	}
+
+	// 群和频道消息默认at发送者
+	atSender := ctx.MessageType != "private"
+	if op.AtSender != nil {
+		atSender = *op.AtSender
+	}
+	if userID := segmentValueToString(ctx.UserID); atSender && ctx.MessageType != "private" && userID != "" {
+		atSegment := map[string]interface{}{
+			"type": "at",
+			"data": map[string]interface{}{
+				"qq": userID,
 			},
 		}
+		switch r := reply.(type) {
+		case string:
+			reply = "[CQ:at,qq=" + userID + "] " + r
+		case []interface{}:
+			reply = append([]interface{}{atSegment, textSegment(" ")}, r...)
+		case map[string]interface{}:
+			reply = []interface{}{atSegment, textSegment(" "), r}
+		}
+	}
 
+	messageText, foundItems := parseMessageContent(callapi.ParamsContent{Message: reply})
+	replyMsg, err := GenerateReplyMessage(foundItems, messageText)
+	if err != nil {
+		return 0, err
+	}
+	// 快速操作是对该事件的回复 事件来自交互时完成交互
+	var replyTo string
+	if messageID := segmentValueToString(ctx.MessageID); messageID != "" {
+		replyTo, _, _ = resolveMessageID(messageID)
+	}
+	sent, err := sendChannelMessage(s, channelID, replyMsg, replyTo)
+	if err != nil {
+		metrics.AddSendFailed()
+		return 0, err
+	}
+	metrics.AddMessageSent()
+	return StoreMessageID(sent.ID, sent.ChannelID), nil
+}
+
+func textSegment(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "text",
+		"data": map[string]interface{}{
+			"text": text,
+		},
+	}
+}
+
+// 还原事件所在的discord频道 优先使用消息记录的频道
+func quickOperationChannel(s *discordgo.Session, ctx callapi.Context) (string, error) {
+	if messageID := segmentValueToString(ctx.MessageID); messageID != "" {
+		if _, channelID, err := resolveMessageID(messageID); err == nil {
+			return channelID, nil
+		}
+	}
+
+	switch ctx.MessageType {
+	case "group":
+		channelID, _, err := resolveGroupGuild(ctx.GroupID)
+		return channelID, err
+	case "guild":
+		if ctx.ChannelID != "" {
+			return ctx.ChannelID, nil
+		}
 	case "private":
-		return &callapi.ActionMessage{
-			Action: "send_private_msg",
-			Params: callapi.ParamsContent{
-				UserID:  strconv.Itoa(originalMsg.Params.Context.UserID), // 将int转换为string
-				Message: originalMsg.Params.Operation.Reply,
-			},
+		realUserID, err := resolveUserID(ctx.UserID)
+		if err != nil {
+			return "", err
 		}
+		channel, err := s.UserChannelCreate(realUserID)
+		if err != nil {
+			return "", err
+		}
+		return channel.ID, nil
+	}
+	return "", fmt.Errorf("%w: cannot resolve channel for message_type %v", callapi.ErrInvalidParams, ctx.MessageType)
+}
+
+// 还原事件所在的discord服务器 私聊不支持踢出和禁言
+func quickOperationGuild(ctx callapi.Context) (string, error) {
+	switch ctx.MessageType {
+	case "group":
+		_, guildID, err := resolveGroupGuild(ctx.GroupID)
+		return guildID, err
+	case "guild":
+		if ctx.GuildID != "" {
+			return ctx.GuildID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: kick and ban are not supported for message_type %v", callapi.ErrInvalidParams, ctx.MessageType)
+}
 
-	default:
-		return nil // 或处理其他类型消息的逻辑
+// 请求事件交给对应的set_xxx_add_request处理,未指定approve时不处理
+func quickHandleRequest(s *discordgo.Session, ctx callapi.Context, op callapi.Operation) error {
+	if op.Approve == nil {
+		return nil
+	}
+	action := "set_" + ctx.RequestType + "_add_request"
+	handler, ok := callapi.GetHandler(action)
+	if !ok {
+		return fmt.Errorf("%w: unsupported request_type %v", callapi.ErrInvalidParams, ctx.RequestType)
 	}
+	_, err := handler(&silentClient{}, s, callapi.ActionMessage{
+		Action: action,
+		Params: callapi.ParamsContent{
+			Flag:    ctx.Flag,
+			SubType: ctx.SubType,
+			Approve: op.Approve,
+			Reason:  op.Reason,
+			Remark:  op.Remark,
+		},
+	})
+	return err
+}
+
+// 组合调用其他handler时使用,回执由外层handler统一发送
+type silentClient struct{}
+
+func (c *silentClient) SendMessage(message map[string]interface{}) error {
+	return nil
 }
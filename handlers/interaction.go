@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/echo"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 交互token的有效期为15分钟,留出余量避免发送时恰好过期
+const interactionTokenTTL = 14*time.Minute + 30*time.Second
+
+// 已延迟响应(显示"正在思考")、等待应用端回复的交互
+type pendingInteraction struct {
+	interaction *discordgo.Interaction
+	createdAt   time.Time
+}
+
+var (
+	pendingInteractionsMu sync.Mutex
+	// key为交互id,即上报的消息对应的真实消息id
+	pendingInteractions = make(map[string]pendingInteraction)
+)
+
+// StorePendingInteraction 记录已延迟响应的交互,引用该交互上报的消息或使用其echo发送的消息将作为它的回复
+func StorePendingInteraction(interaction *discordgo.Interaction) {
+	pendingInteractionsMu.Lock()
+	defer pendingInteractionsMu.Unlock()
+	// 顺带清理所有过期的交互
+	for id, pending := range pendingInteractions {
+		if time.Since(pending.createdAt) >= interactionTokenTTL {
+			delete(pendingInteractions, id)
+		}
+	}
+	pendingInteractions[interaction.ID] = pendingInteraction{
+		interaction: interaction,
+		createdAt:   time.Now(),
+	}
+}
+
+// 取出等待回复的交互 不存在或已过期时返回nil
+func takePendingInteraction(id string) *discordgo.Interaction {
+	if id == "" {
+		return nil
+	}
+	pendingInteractionsMu.Lock()
+	defer pendingInteractionsMu.Unlock()
+	pending, ok := pendingInteractions[id]
+	if !ok {
+		return nil
+	}
+	delete(pendingInteractions, id)
+	if time.Since(pending.createdAt) >= interactionTokenTTL {
+		return nil
+	}
+	return pending.interaction
+}
+
+// 交互被黑白名单拦截、不会上报时,不再等待回复并删除"正在思考"的提示
+func discardPendingInteraction(s *discordgo.Session, id string) {
+	interaction := takePendingInteraction(id)
+	if interaction == nil {
+		return
+	}
+	if err := s.InteractionResponseDelete(interaction); err != nil {
+		mylog.Printf("删除交互延迟响应失败: %v", err)
+	}
+}
+
+// 消息回复的目标 优先取消息中的reply,其次取echo对应的消息id
+func replyTarget(msg *discordgo.MessageSend, echoValue interface{}) string {
+	if msg.Reference != nil && msg.Reference.MessageID != "" {
+		return msg.Reference.MessageID
+	}
+	if echoStr, ok := echoValue.(string); ok {
+		return echo.GetMsgIDByKey(echoStr)
+	}
+	return ""
+}
+
+// 发送消息到频道 replyTo是等待回复的交互时,作为交互的回复发送
+func sendChannelMessage(s *discordgo.Session, channelID string, msg *discordgo.MessageSend, replyTo string) (*discordgo.Message, error) {
+	if interaction := takePendingInteraction(replyTo); interaction != nil {
+		sent, err := completeInteraction(s, interaction, msg)
+		if err == nil {
+			return sent, nil
+		}
+		mylog.Printf("回复交互失败,改为发送普通消息: %v", err)
+		rewindFiles(msg.Files)
+	}
+	return s.ChannelMessageSendComplex(channelID, msg)
+}
+
+// 编辑延迟响应的原始回复,失败时(如原始回复已被删除)发送followup消息
+func completeInteraction(s *discordgo.Session, interaction *discordgo.Interaction, msg *discordgo.MessageSend) (*discordgo.Message, error) {
+	edit := &discordgo.WebhookEdit{
+		Files:           msg.Files,
+		AllowedMentions: msg.AllowedMentions,
+	}
+	if msg.Content != "" {
+		edit.Content = &msg.Content
+	}
+	if len(msg.Embeds) > 0 {
+		edit.Embeds = &msg.Embeds
+	}
+	if len(msg.Components) > 0 {
+		edit.Components = &msg.Components
+	}
+	sent, err := s.InteractionResponseEdit(interaction, edit)
+	if err == nil {
+		return sent, nil
+	}
+	mylog.Printf("编辑交互回复失败,改为发送followup: %v", err)
+	rewindFiles(msg.Files)
+
+	return s.FollowupMessageCreate(interaction, true, &discordgo.WebhookParams{
+		Content:         msg.Content,
+		Files:           msg.Files,
+		Components:      msg.Components,
+		Embeds:          msg.Embeds,
+		AllowedMentions: msg.AllowedMentions,
+	})
+}
+
+// 发送失败后重新读取附件
+func rewindFiles(files []*discordgo.File) {
+	for _, file := range files {
+		if seeker, ok := file.Reader.(io.Seeker); ok {
+			seeker.Seek(0, io.SeekStart)
+		}
+	}
+}
@@ -6,16 +6,15 @@ import (
 	"github.com/bwmarrin/discordgo"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
-	"github.com/tidwall/gjson"
 )
 
 type MarkThisMessageAsReadAPIResponse struct {
-	Status  string       `json:"status"`
-	Data    gjson.Result `json:"data"`
-	Msg     string       `json:"msg"`
-	Wording string       `json:"wording"`
-	RetCode int64        `json:"retcode"`
-	Echo    interface{}  `json:"echo"`
+	Status  string      `json:"status"`
+	Data    interface{} `json:"data"`
+	Msg     string      `json:"msg"`
+	Wording string      `json:"wording"`
+	RetCode int64       `json:"retcode"`
+	Echo    interface{} `json:"echo"`
 }
 
 func init() {
@@ -26,7 +25,6 @@ func MarkThisMessageAsRead(client callapi.Client, s *discordgo.Session, message
 
 	var response MarkThisMessageAsReadAPIResponse
 
-	response.Data.Str = "123"
 	response.Msg = "123"
 	response.RetCode = 0
 	response.Status = "ok"
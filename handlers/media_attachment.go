@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// discord客户端可以直接播放的音频格式
+var playableAudioTypes = map[string]bool{
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"application/ogg": true,
+	"audio/wave":      true,
+	"audio/flac":      true,
+}
+
+// 常见媒体类型对应的后缀 mime.ExtensionsByType在不同系统上结果不一致
+var mediaExtensions = map[string]string{
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+	"application/ogg": ".ogg",
+	"audio/wave":      ".wav",
+	"audio/flac":      ".flac",
+	"audio/silk":      ".silk",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/avi":       ".avi",
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+}
+
+// opus支持的采样率
+var opusSampleRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// 按file的协议头将语音/视频归类到foundItems 与cq码正则得到的key保持一致
+func appendMediaItem(foundItems map[string][]string, kind string, file string) {
+	switch {
+	case file == "":
+		return
+	case strings.HasPrefix(file, "base64://"):
+		foundItems["base64_"+kind] = append(foundItems["base64_"+kind], strings.TrimPrefix(file, "base64://"))
+	case strings.HasPrefix(file, "http://"):
+		foundItems["url_"+kind] = append(foundItems["url_"+kind], strings.TrimPrefix(file, "http://"))
+	case strings.HasPrefix(file, "https://"):
+		foundItems["url_"+kind+"s"] = append(foundItems["url_"+kind+"s"], strings.TrimPrefix(file, "https://"))
+	default:
+		foundItems["local_"+kind] = append(foundItems["local_"+kind], trimFileScheme(file))
+	}
+}
+
+// 还原foundItems中某类媒体的原始地址
+func mediaSources(foundItems map[string][]string, kind string) []string {
+	var sources []string
+	for _, v := range foundItems["base64_"+kind] {
+		sources = append(sources, "base64://"+v)
+	}
+	for _, v := range foundItems["local_"+kind] {
+		sources = append(sources, "file://"+v)
+	}
+	for _, v := range foundItems["url_"+kind] {
+		sources = append(sources, "http://"+v)
+	}
+	for _, v := range foundItems["url_"+kind+"s"] {
+		sources = append(sources, "https://"+v)
+	}
+	return sources
+}
+
+// 去掉file://协议头 windows下为file:///
+func trimFileScheme(file string) string {
+	if runtime.GOOS == "windows" && strings.HasPrefix(file, "file:///") {
+		return strings.TrimPrefix(file, "file:///")
+	}
+	return strings.TrimPrefix(file, "file://")
+}
+
+// 读取base64/本地/网络资源,返回数据和原始文件名(可能为空)
+func readMediaSource(source string) ([]byte, string, error) {
+	switch {
+	case strings.HasPrefix(source, "base64://"):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(source, "base64://"))
+		return data, "", err
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		data, err := downloadImage(source)
+		if err != nil {
+			return nil, "", err
+		}
+		name := filepath.Base(strings.SplitN(source, "?", 2)[0])
+		return data, name, nil
+	default:
+		path := trimFileScheme(source)
+		data, err := os.ReadFile(path)
+		return data, filepath.Base(path), err
+	}
+}
+
+// 识别数据的MIME类型
+func detectMediaType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("fLaC")) {
+		return "audio/flac"
+	}
+	if bytes.HasPrefix(data, []byte("#!SILK")) || bytes.HasPrefix(data, []byte("\x02#!SILK")) {
+		return "audio/silk"
+	}
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// 根据内容生成带正确后缀和MIME的附件,name为空时使用base加推断的后缀
+func newMediaFile(data []byte, base string, name string) *discordgo.File {
+	contentType := detectMediaType(data)
+	if name == "" || filepath.Ext(name) == "" {
+		ext, ok := mediaExtensions[contentType]
+		if !ok {
+			if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+				ext = exts[0]
+			} else {
+				ext = ".bin"
+			}
+		}
+		if name == "" {
+			name = base
+		}
+		name += ext
+	} else if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" && contentType == "application/octet-stream" {
+		// 内容无法识别时 信任文件名的后缀
+		contentType = byExt
+	}
+	return &discordgo.File{
+		Name:        name,
+		ContentType: contentType,
+		Reader:      bytes.NewReader(data),
+	}
+}
+
+// 生成语音附件 discord无法播放的格式按record_sampleRate和record_bitRate转码为ogg/opus
+func newRecordFile(data []byte) *discordgo.File {
+	if playableAudioTypes[detectMediaType(data)] {
+		return newMediaFile(data, "record", "")
+	}
+	transcoded, err := transcodeRecord(data)
+	if err != nil {
+		mylog.Printf("语音转码失败,按原格式发送: %v", err)
+		return newMediaFile(data, "record", "")
+	}
+	return &discordgo.File{
+		Name:        "record.ogg",
+		ContentType: "audio/ogg",
+		Reader:      bytes.NewReader(transcoded),
+	}
+}
+
+// 使用ffmpeg将语音转为ogg/opus
+func transcodeRecord(data []byte) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("未找到ffmpeg: %v", err)
+	}
+
+	sampleRate := closestOpusSampleRate(config.GetRecordSampleRate())
+	bitRate := config.GetRecordBitRate()
+	if bitRate <= 0 {
+		bitRate = 24000
+	}
+
+	cmd := exec.Command(ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-vn",
+		"-c:a", "libopus",
+		"-ar", strconv.Itoa(sampleRate),
+		"-b:a", strconv.Itoa(bitRate),
+		"-f", "ogg",
+		"pipe:1",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// opus只支持固定的几种采样率,取不超过配置值的最大一档
+func closestOpusSampleRate(sampleRate int) int {
+	if sampleRate <= 0 {
+		return 24000
+	}
+	result := opusSampleRates[0]
+	for _, rate := range opusSampleRates {
+		if rate <= sampleRate {
+			result = rate
+		}
+	}
+	return result
+}
+
+// qq音乐转换为链接embed
+func newQQMusicEmbed(songID string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Type:  discordgo.EmbedTypeLink,
+		Title: "QQ音乐 " + songID,
+		URL:   "https://i.y.qq.com/v8/playsong.html?songid=" + songID,
+	}
+}
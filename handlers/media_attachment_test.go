@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+)
+
+func TestAppendMediaItem(t *testing.T) {
+	tests := []struct {
+		file string
+		key  string
+		want string
+	}{
+		{"base64://aGk=", "base64_record", "aGk="},
+		{"http://example.com/a.mp3", "url_record", "example.com/a.mp3"},
+		{"https://example.com/a.mp3", "url_records", "example.com/a.mp3"},
+		{"file:///tmp/a.mp3", "local_record", "/tmp/a.mp3"},
+	}
+	for _, tt := range tests {
+		foundItems := make(map[string][]string)
+		appendMediaItem(foundItems, "record", tt.file)
+		if got := foundItems[tt.key]; len(got) != 1 || got[0] != tt.want {
+			t.Errorf("appendMediaItem(%q): foundItems = %v, want %s=[%s]", tt.file, foundItems, tt.key, tt.want)
+		}
+		// 与发送时的还原一致
+		if sources := mediaSources(foundItems, "record"); len(sources) != 1 || sources[0] != tt.file {
+			t.Errorf("mediaSources after appendMediaItem(%q) = %v", tt.file, sources)
+		}
+	}
+
+	foundItems := make(map[string][]string)
+	appendMediaItem(foundItems, "video", "")
+	if len(foundItems) != 0 {
+		t.Errorf("empty file should be ignored, got %v", foundItems)
+	}
+}
+
+func TestParseMessageContentMedia(t *testing.T) {
+	tests := []struct {
+		name    string
+		message interface{}
+		text    string
+		items   map[string][]string
+	}{
+		{
+			name:    "cq file with name",
+			message: "see [CQ:file,file=https://example.com/a.txt,name=a.txt]",
+			text:    "see ",
+			items:   map[string][]string{"file": {"https://example.com/a.txt"}, "file_name": {"a.txt"}},
+		},
+		{
+			name:    "cq file without name",
+			message: "[CQ:file,file=base64://aGk=]",
+			items:   map[string][]string{"file": {"base64://aGk="}, "file_name": {""}},
+		},
+		{
+			name:    "cq video",
+			message: "[CQ:video,file=base64://aGk=]",
+			items:   map[string][]string{"base64_video": {"aGk="}},
+		},
+		{
+			name: "segments",
+			message: []interface{}{
+				map[string]interface{}{"type": "record", "data": map[string]interface{}{"file": "http://example.com/a.silk"}},
+				map[string]interface{}{"type": "video", "data": map[string]interface{}{"file": "https://example.com/a.mp4"}},
+				map[string]interface{}{"type": "file", "data": map[string]interface{}{"file": "https://example.com/b.zip", "name": "b.zip"}},
+			},
+			items: map[string][]string{
+				"url_record": {"example.com/a.silk"},
+				"url_videos": {"example.com/a.mp4"},
+				"file":       {"https://example.com/b.zip"},
+				"file_name":  {"b.zip"},
+			},
+		},
+		{
+			name:    "single file segment",
+			message: map[string]interface{}{"type": "file", "data": map[string]interface{}{"file": "file:///tmp/c.txt"}},
+			items:   map[string][]string{"file": {"file:///tmp/c.txt"}, "file_name": {""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, items := parseMessageContent(callapi.ParamsContent{Message: tt.message})
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(items, tt.items) {
+				t.Errorf("items = %v, want %v", items, tt.items)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+var (
+	// 新版客户端发出的提及不带!
+	userMentionPattern    = regexp.MustCompile(`<@!?(\d+)>`)
+	roleMentionPattern    = regexp.MustCompile(`<@&(\d+)>`)
+	channelMentionPattern = regexp.MustCompile(`<#(\d+)>`)
+	customEmojiPattern    = regexp.MustCompile(`<(a?):(\w+):(\d+)>`)
+	everyonePattern       = regexp.MustCompile(`@(everyone|here)`)
+
+	// 应用端发出的at
+	cqAtPattern     = regexp.MustCompile(`\[CQ:at,qq=([^,\]]+)[^\]]*\]`)
+	cqAtRolePattern = regexp.MustCompile(`\[CQ:at_role,id=([^,\]]+)[^\]]*\]`)
+)
+
+// 将身份组、全体成员、子频道提及和自定义表情转换为cq码
+func revertExtraMentions(messageText string, msg *discordgo.Message, s *discordgo.Session) string {
+	messageText = roleMentionPattern.ReplaceAllStringFunc(messageText, func(m string) string {
+		segment := roleMentionSegment(msg.GuildID, roleMentionPattern.FindStringSubmatch(m)[1])
+		return segmentToCQCode(segment)
+	})
+	if msg.MentionEveryone {
+		messageText = everyonePattern.ReplaceAllString(messageText, "[CQ:at,qq=all]")
+	}
+	messageText = channelMentionPattern.ReplaceAllStringFunc(messageText, func(m string) string {
+		return channelMentionText(s, channelMentionPattern.FindStringSubmatch(m)[1])
+	})
+	messageText = customEmojiPattern.ReplaceAllStringFunc(messageText, func(m string) string {
+		submatches := customEmojiPattern.FindStringSubmatch(m)
+		return segmentToCQCode(customEmojiSegment(submatches[1] == "a", submatches[2], submatches[3]))
+	})
+	return messageText
+}
+
+// 从内容中取出身份组、全体成员提及和自定义表情作为消息段,子频道提及保留为文本
+func extractExtraMentionSegments(content string, msg *discordgo.Message, s *discordgo.Session) (string, []map[string]interface{}) {
+	var segments []map[string]interface{}
+	for _, match := range roleMentionPattern.FindAllStringSubmatch(content, -1) {
+		segments = append(segments, roleMentionSegment(msg.GuildID, match[1]))
+	}
+	content = roleMentionPattern.ReplaceAllString(content, "")
+	if msg.MentionEveryone && everyonePattern.MatchString(content) {
+		segments = append(segments, map[string]interface{}{
+			"type": "at",
+			"data": map[string]interface{}{
+				"qq": "all",
+			},
+		})
+		content = everyonePattern.ReplaceAllString(content, "")
+	}
+	for _, match := range customEmojiPattern.FindAllStringSubmatch(content, -1) {
+		segments = append(segments, customEmojiSegment(match[1] == "a", match[2], match[3]))
+	}
+	content = customEmojiPattern.ReplaceAllString(content, "")
+	content = channelMentionPattern.ReplaceAllStringFunc(content, func(m string) string {
+		return channelMentionText(s, channelMentionPattern.FindStringSubmatch(m)[1])
+	})
+	return content, segments
+}
+
+// 身份组提及 @everyone身份组的id与guild_id相同,转换为qq=all
+func roleMentionSegment(guildID string, roleID string) map[string]interface{} {
+	if roleID == guildID {
+		return map[string]interface{}{
+			"type": "at",
+			"data": map[string]interface{}{
+				"qq": "all",
+			},
+		}
+	}
+	roleID64, err := idmap.StoreIDv2(roleID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+	} else {
+		roleID = strconv.FormatInt(roleID64, 10)
+	}
+	return map[string]interface{}{
+		"type": "at_role",
+		"data": map[string]interface{}{
+			"id": roleID,
+		},
+	}
+}
+
+// 自定义表情转为图片 subType=1代表表情
+func customEmojiSegment(animated bool, name string, emojiID string) map[string]interface{} {
+	ext := ".png"
+	if animated {
+		ext = ".gif"
+	}
+	return map[string]interface{}{
+		"type": "image",
+		"data": map[string]interface{}{
+			"file":    name + ".image",
+			"subType": "1",
+			"url":     "https://cdn.discordapp.com/emojis/" + emojiID + ext,
+		},
+	}
+}
+
+// 子频道提及转为#频道名,获取不到时保留id
+func channelMentionText(s *discordgo.Session, channelID string) string {
+	if s != nil {
+		channel, err := s.State.Channel(channelID)
+		if err == nil && channel.Name != "" {
+			return "#" + channel.Name
+		}
+	}
+	return "#" + channelID
+}
+
+// 将本文件生成的消息段渲染为cq码
+func segmentToCQCode(segment map[string]interface{}) string {
+	data, _ := segment["data"].(map[string]interface{})
+	switch segment["type"] {
+	case "at":
+		return "[CQ:at,qq=" + segmentValueToString(data["qq"]) + "]"
+	case "at_role":
+		return "[CQ:at_role,id=" + segmentValueToString(data["id"]) + "]"
+	case "image":
+		return "[CQ:image,file=" + segmentValueToString(data["file"]) + ",subType=" + segmentValueToString(data["subType"]) + ",url=" + segmentValueToString(data["url"]) + "]"
+	}
+	return ""
+}
+
+// 将应用端发出的cq码at转换为discord提及
+func transformAtToMention(messageText string) string {
+	messageText = cqAtPattern.ReplaceAllStringFunc(messageText, func(m string) string {
+		return atToMention(cqAtPattern.FindStringSubmatch(m)[1])
+	})
+	messageText = cqAtRolePattern.ReplaceAllStringFunc(messageText, func(m string) string {
+		return "<@&" + realIDOrSelf(cqAtRolePattern.FindStringSubmatch(m)[1]) + ">"
+	})
+	return messageText
+}
+
+// 将at的qq值通过idmap还原为discord提及
+func atToMention(qq string) string {
+	switch {
+	case qq == "all":
+		return "@everyone"
+	case qq == AppID && BotID != "":
+		return "<@" + BotID + ">"
+	}
+	return "<@" + realIDOrSelf(qq) + ">"
+}
+
+// 还原虚拟id,找不到映射时(如string_ob11)认为已经是真实id
+func realIDOrSelf(vid string) string {
+	if config.GetStringOb11() {
+		return vid
+	}
+	realID, err := idmap.RetrieveRowByIDv2(vid)
+	if err != nil || realID == "" {
+		return vid
+	}
+	return realID
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+)
+
+const (
+	testBotID = "900000000000000001"
+	testAppID = "1234"
+)
+
+// 提及的转换依赖idmap和机器人id 使用临时数据库
+func setupMentionTest(t *testing.T) {
+	t.Helper()
+	if err := idmap.OpenDB(filepath.Join(t.TempDir(), "idmap.db")); err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(idmap.CloseDB)
+
+	botID, appID := BotID, AppID
+	BotID, AppID = testBotID, testAppID
+	t.Cleanup(func() { BotID, AppID = botID, appID })
+}
+
+// 获取真实id对应的虚拟id
+func virtualID(t *testing.T, realID string) string {
+	t.Helper()
+	id, err := idmap.StoreIDv2(realID)
+	if err != nil {
+		t.Fatalf("StoreIDv2(%q): %v", realID, err)
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+func TestRevertTransformedTextUserMention(t *testing.T) {
+	setupMentionTest(t)
+	at := "[CQ:at,qq=" + virtualID(t, "800000000000000001") + "]"
+
+	for content, want := range map[string]string{
+		"<@800000000000000001> hi":  at + " hi",
+		"<@!800000000000000001> hi": at + " hi",
+		"<@" + testBotID + "> hi":   "[CQ:at,qq=" + testAppID + "] hi",
+		"hello":                     "hello",
+	} {
+		data := &discordgo.MessageCreate{Message: &discordgo.Message{Content: content}}
+		if got := RevertTransformedText(data, "", nil, 0); got != want {
+			t.Errorf("RevertTransformedText(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestRevertExtraMentions(t *testing.T) {
+	setupMentionTest(t)
+	roleID := virtualID(t, "700000000000000001")
+
+	tests := []struct {
+		content  string
+		everyone bool
+		want     string
+	}{
+		{"<@&700000000000000001> go", false, "[CQ:at_role,id=" + roleID + "] go"},
+		// 与频道id相同的身份组即@everyone
+		{"<@&600000000000000001>", false, "[CQ:at,qq=all]"},
+		{"@everyone go", true, "[CQ:at,qq=all] go"},
+		{"@here go", true, "[CQ:at,qq=all] go"},
+		{"@everyone go", false, "@everyone go"},
+		{"see <#500000000000000001>", false, "see #500000000000000001"},
+		{"<:smile:400000000000000001>", false, "[CQ:image,file=smile.image,subType=1,url=https://cdn.discordapp.com/emojis/400000000000000001.png]"},
+		{"<a:dance:400000000000000002>", false, "[CQ:image,file=dance.image,subType=1,url=https://cdn.discordapp.com/emojis/400000000000000002.gif]"},
+	}
+	for _, tt := range tests {
+		msg := &discordgo.Message{
+			GuildID:         "600000000000000001",
+			Content:         tt.content,
+			MentionEveryone: tt.everyone,
+		}
+		if got := revertExtraMentions(tt.content, msg, nil); got != tt.want {
+			t.Errorf("revertExtraMentions(%q, everyone=%v) = %q, want %q", tt.content, tt.everyone, got, tt.want)
+		}
+	}
+}
+
+func TestTransformAtToMention(t *testing.T) {
+	setupMentionTest(t)
+	userID := virtualID(t, "800000000000000003")
+	roleID := virtualID(t, "700000000000000003")
+
+	// atToMention
+	for qq, want := range map[string]string{
+		"all":     "@everyone",
+		testAppID: "<@" + testBotID + ">",
+		userID:    "<@800000000000000003>",
+		"999999":  "<@999999>",
+	} {
+		if got := atToMention(qq); got != want {
+			t.Errorf("atToMention(%q) = %q, want %q", qq, got, want)
+		}
+	}
+
+	for text, want := range map[string]string{
+		"[CQ:at,qq=" + userID + "] hi":       "<@800000000000000003> hi",
+		"[CQ:at,qq=" + userID + ",name=foo]": "<@800000000000000003>",
+		"[CQ:at,qq=all] hi":                  "@everyone hi",
+		"[CQ:at_role,id=" + roleID + "]":     "<@&700000000000000003>",
+		"hi":                                 "hi",
+	} {
+		if got := transformAtToMention(text); got != want {
+			t.Errorf("transformAtToMention(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -29,22 +29,27 @@ type ServerResponse struct {
 		MessageID int `json:"message_id"`
 	} `json:"data"`
 	Message string      `json:"message"`
+	Msg     string      `json:"msg,omitempty"`
+	Wording string      `json:"wording,omitempty"`
 	RetCode int         `json:"retcode"`
 	Status  string      `json:"status"`
 	Echo    interface{} `json:"echo"`
 }
 
-// 发送成功回执 todo 返回可互转的messageid
-func SendResponse(client callapi.Client, err error, message *callapi.ActionMessage) (string, error) {
+// 发送回执,err不为空时返回failed 开启always_ok_response时保持旧行为
+// messageID为StoreMessageID返回的可互转message_id,没有时传0
+func SendResponse(client callapi.Client, err error, message *callapi.ActionMessage, messageID int) (string, error) {
 	// 设置响应值
 	response := ServerResponse{}
-	response.Data.MessageID = 0 // todo 实现messageid转换
+	response.Data.MessageID = messageID
 	response.Echo = message.Echo
-	if err != nil {
+	if err != nil && !config.GetAlwaysOkResponse() {
+		response.RetCode, response.Msg, response.Wording = callapi.DescribeError(err)
+		response.Message = response.Wording
+		response.Status = "failed"
+	} else if err != nil {
 		response.Message = err.Error() // 可选：在响应中添加错误消息
-		//response.RetCode = -1          // 可以是任何非零值，表示出错
-		//response.Status = "failed"
-		response.RetCode = 0 //官方api审核异步的 审核中默认返回失败,但其实信息发送成功了
+		response.RetCode = 0           //兼容旧行为 失败时也返回ok
 		response.Status = "ok"
 	} else {
 		response.Message = ""
@@ -71,6 +76,17 @@ func SendResponse(client callapi.Client, err error, message *callapi.ActionMessa
 	return string(jsonResponse), nil
 }
 
+// StoreMessageID 将discord的消息id映射为int,并记录消息所在的频道,供delete_msg和get_msg还原
+func StoreMessageID(messageID string, channelID string) int {
+	messageID64, err := idmap.StoreIDv2(messageID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return 0
+	}
+	idmap.WriteConfigv2(messageID, "channel_id", channelID)
+	return int(messageID64)
+}
+
 // 信息处理函数
 func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[string][]string) {
 	messageText := ""
@@ -106,11 +122,33 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 
 			case "voice", "record":
 				fileContent, _ := segmentMap["data"].(map[string]interface{})["file"].(string)
-				foundItems["record"] = append(foundItems["record"], fileContent)
+				appendMediaItem(foundItems, "record", fileContent)
+
+			case "video":
+				fileContent, _ := segmentMap["data"].(map[string]interface{})["file"].(string)
+				appendMediaItem(foundItems, "video", fileContent)
+
+			case "file":
+				fileContent, _ := segmentMap["data"].(map[string]interface{})["file"].(string)
+				fileName, _ := segmentMap["data"].(map[string]interface{})["name"].(string)
+				foundItems["file"] = append(foundItems["file"], fileContent)
+				foundItems["file_name"] = append(foundItems["file_name"], fileName)
+
+			case "reply":
+				replyID := segmentValueToString(segmentMap["data"].(map[string]interface{})["id"])
+				foundItems["reply"] = append(foundItems["reply"], replyID)
+
+			case "music":
+				musicType, _ := segmentMap["data"].(map[string]interface{})["type"].(string)
+				musicID, _ := segmentMap["data"].(map[string]interface{})["id"].(string)
+				if musicType == "qq" {
+					foundItems["qqmusic"] = append(foundItems["qqmusic"], musicID)
+				}
 
 			case "at":
-				qqNumber, _ := segmentMap["data"].(map[string]interface{})["qq"].(string)
+				qqNumber := segmentValueToString(segmentMap["data"].(map[string]interface{})["qq"])
 				foundItems["at"] = append(foundItems["at"], qqNumber)
+				segmentContent = atToMention(qqNumber)
 
 			case "markdown":
 				mdContent, ok := segmentMap["data"].(map[string]interface{})["data"]
@@ -174,11 +212,33 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 
 		case "voice", "record":
 			fileContent, _ := message["data"].(map[string]interface{})["file"].(string)
-			foundItems["record"] = append(foundItems["record"], fileContent)
+			appendMediaItem(foundItems, "record", fileContent)
+
+		case "video":
+			fileContent, _ := message["data"].(map[string]interface{})["file"].(string)
+			appendMediaItem(foundItems, "video", fileContent)
+
+		case "file":
+			fileContent, _ := message["data"].(map[string]interface{})["file"].(string)
+			fileName, _ := message["data"].(map[string]interface{})["name"].(string)
+			foundItems["file"] = append(foundItems["file"], fileContent)
+			foundItems["file_name"] = append(foundItems["file_name"], fileName)
+
+		case "reply":
+			replyID := segmentValueToString(message["data"].(map[string]interface{})["id"])
+			foundItems["reply"] = append(foundItems["reply"], replyID)
+
+		case "music":
+			musicType, _ := message["data"].(map[string]interface{})["type"].(string)
+			musicID, _ := message["data"].(map[string]interface{})["id"].(string)
+			if musicType == "qq" {
+				foundItems["qqmusic"] = append(foundItems["qqmusic"], musicID)
+			}
 
 		case "at":
-			qqNumber, _ := message["data"].(map[string]interface{})["qq"].(string)
+			qqNumber := segmentValueToString(message["data"].(map[string]interface{})["qq"])
 			foundItems["at"] = append(foundItems["at"], qqNumber)
+			messageText = atToMention(qqNumber)
 
 		case "markdown":
 			mdContent, ok := message["data"].(map[string]interface{})["data"]
@@ -226,9 +286,12 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 
 	// 当匹配到复古cq码上报类型,使用低效率正则.
 	if _, ok := paramsMessage.Message.(string); ok {
+		// 将at转换为discord提及 保留其在文本中的位置
+		messageText = transformAtToMention(messageText)
 		// 正则表达式部分
 		var localImagePattern *regexp.Regexp
 		var localRecordPattern *regexp.Regexp
+		var localVideoPattern *regexp.Regexp
 		if runtime.GOOS == "windows" {
 			localImagePattern = regexp.MustCompile(`\[CQ:image,file=file:///([^\]]+?)\]`)
 		} else {
@@ -239,6 +302,11 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 		} else {
 			localRecordPattern = regexp.MustCompile(`\[CQ:record,file=file://([^\]]+?)\]`)
 		}
+		if runtime.GOOS == "windows" {
+			localVideoPattern = regexp.MustCompile(`\[CQ:video,file=file:///([^\]]+?)\]`)
+		} else {
+			localVideoPattern = regexp.MustCompile(`\[CQ:video,file=file://([^\]]+?)\]`)
+		}
 		httpUrlImagePattern := regexp.MustCompile(`\[CQ:image,file=http://(.+?)\]`)
 		httpsUrlImagePattern := regexp.MustCompile(`\[CQ:image,file=https://(.+?)\]`)
 		base64ImagePattern := regexp.MustCompile(`\[CQ:image,file=base64://(.+?)\]`)
@@ -247,8 +315,11 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 		httpsUrlRecordPattern := regexp.MustCompile(`\[CQ:record,file=https://(.+?)\]`)
 		httpUrlVideoPattern := regexp.MustCompile(`\[CQ:video,file=http://(.+?)\]`)
 		httpsUrlVideoPattern := regexp.MustCompile(`\[CQ:video,file=https://(.+?)\]`)
+		base64VideoPattern := regexp.MustCompile(`\[CQ:video,file=base64://(.+?)\]`)
+		filePattern := regexp.MustCompile(`\[CQ:file,file=([^,\]]+)(?:,name=([^,\]]+))?[^\]]*\]`)
 		mdPattern := regexp.MustCompile(`\[CQ:markdown,data=base64://(.+?)\]`)
 		qqMusicPattern := regexp.MustCompile(`\[CQ:music,type=qq,id=(\d+)\]`)
+		replyPattern := regexp.MustCompile(`\[CQ:reply,id=([^,\]]+)[^\]]*\]`)
 
 		patterns := []struct {
 			key     string
@@ -264,9 +335,19 @@ func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[strin
 			{"url_records", httpsUrlRecordPattern},
 			{"markdown", mdPattern},
 			{"qqmusic", qqMusicPattern},
+			{"reply", replyPattern},
 			{"url_video", httpUrlVideoPattern},
 			{"url_videos", httpsUrlVideoPattern},
+			{"local_video", localVideoPattern},
+			{"base64_video", base64VideoPattern},
+		}
+
+		// 文件需要同时取出file和name
+		for _, match := range filePattern.FindAllStringSubmatch(messageText, -1) {
+			foundItems["file"] = append(foundItems["file"], match[1])
+			foundItems["file_name"] = append(foundItems["file_name"], match[2])
 		}
+		messageText = filePattern.ReplaceAllString(messageText, "")
 
 		for _, pattern := range patterns {
 			matches := pattern.pattern.FindAllStringSubmatch(messageText, -1)
@@ -324,8 +405,8 @@ func RevertTransformedText(data interface{}, msgtype string, s *discordgo.Sessio
 	// 将messageText里的BotID替换成AppID
 	messageText = strings.ReplaceAll(messageText, BotID, AppID)
 
-	// 使用正则表达式来查找所有<@!数字>的模式
-	re := regexp.MustCompile(`<@!(\d+)>`)
+	// 使用正则表达式来查找所有<@!数字>和<@数字>的模式
+	re := userMentionPattern
 	// 使用正则表达式来替换找到的模式为[CQ:at,qq=用户ID]
 	messageText = re.ReplaceAllStringFunc(messageText, func(m string) string {
 		submatches := re.FindStringSubmatch(m)
@@ -354,6 +435,8 @@ func RevertTransformedText(data interface{}, msgtype string, s *discordgo.Sessio
 		}
 		return m
 	})
+	// 转换身份组、全体成员、子频道提及和自定义表情
+	messageText = revertExtraMentions(messageText, msg, s)
 	//结构 <@!>空格/内容
 	//如果移除了前部at,信息就会以空格开头,因为只移去了最前面的at,但at后紧跟随一个空格
 	if config.GetRemoveAt() {
@@ -462,11 +545,45 @@ func RevertTransformedText(data interface{}, msgtype string, s *discordgo.Sessio
 		}
 	}
 
+	// 被拦截的交互不会上报,应用端也不会回复
+	if messageText == "" {
+		discardPendingInteraction(s, msg.ID)
+	}
+
+	// 回复了其他消息时 在最前面加上reply 放在过滤之后避免影响指令前缀判断
+	if messageText != "" {
+		if replyID := replyMessageID(msg); replyID != "" {
+			messageText = "[CQ:reply,id=" + replyID + "]" + messageText
+		}
+	}
+
 	return messageText
 }
 
+// 获取被回复消息的message_id,不是回复消息时返回空
+func replyMessageID(msg *discordgo.Message) string {
+	if msg.Type != discordgo.MessageTypeReply || msg.MessageReference == nil || msg.MessageReference.MessageID == "" {
+		return ""
+	}
+	ref := msg.MessageReference
+	channelID := ref.ChannelID
+	if channelID == "" {
+		channelID = msg.ChannelID
+	}
+	// string_ob11上报的是真实id
+	if config.GetStringOb11() {
+		idmap.WriteConfigv2(ref.MessageID, "channel_id", channelID)
+		return ref.MessageID
+	}
+	replyID := StoreMessageID(ref.MessageID, channelID)
+	if replyID == 0 {
+		return ""
+	}
+	return strconv.Itoa(replyID)
+}
+
 // 将收到的data.content转换为message segment todo,群场景不支持受图片,频道场景的图片可以拼一下
-func ConvertToSegmentedMessage(data interface{}) []map[string]interface{} {
+func ConvertToSegmentedMessage(data interface{}, s *discordgo.Session) []map[string]interface{} {
 	// 强制类型转换，获取Message结构
 	var msg *discordgo.Message
 	var menumsg bool
@@ -483,6 +600,17 @@ func ConvertToSegmentedMessage(data interface{}) []map[string]interface{} {
 	}
 	var messageSegments []map[string]interface{}
 
+	// 处理回复
+	if replyID := replyMessageID(msg); replyID != "" {
+		replySegment := map[string]interface{}{
+			"type": "reply",
+			"data": map[string]interface{}{
+				"id": replyID,
+			},
+		}
+		messageSegments = append(messageSegments, replySegment)
+	}
+
 	// 处理Attachments字段来构建图片消息
 	for _, attachment := range msg.Attachments {
 		imageFileMD5 := attachment.Filename
@@ -505,8 +633,8 @@ func ConvertToSegmentedMessage(data interface{}) []map[string]interface{} {
 	}
 	// 将msg.Content里的BotID替换成AppID
 	msg.Content = strings.ReplaceAll(msg.Content, BotID, AppID)
-	// 使用正则表达式查找所有的[@数字]格式
-	r := regexp.MustCompile(`<@!(\d+)>`)
+	// 使用正则表达式查找所有的<@!数字>和<@数字>格式
+	r := userMentionPattern
 	atMatches := r.FindAllStringSubmatch(msg.Content, -1)
 	for _, match := range atMatches {
 		userID := match[1]
@@ -554,6 +682,10 @@ func ConvertToSegmentedMessage(data interface{}) []map[string]interface{} {
 		// 从原始内容中移除at部分
 		msg.Content = strings.Replace(msg.Content, match[0], "", 1)
 	}
+	// 处理身份组、全体成员、子频道提及和自定义表情
+	var extraSegments []map[string]interface{}
+	msg.Content, extraSegments = extractExtraMentionSegments(msg.Content, msg, s)
+	messageSegments = append(messageSegments, extraSegments...)
 	//结构 <@!>空格/内容
 	//如果移除了前部at,信息就会以空格开头,因为只移去了最前面的at,但at后紧跟随一个空格
 	if config.GetRemoveAt() {
@@ -600,13 +732,29 @@ func ConvertToInt64(value interface{}) (int64, error) {
 	}
 }
 
+// 将消息段data中的id等字段统一转为string,json数字会被解码为float64
+func segmentValueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // 排列MessageSegments
 func sortMessageSegments(segments []map[string]interface{}) []map[string]interface{} {
-	var atSegments, textSegments, imageSegments []map[string]interface{}
+	var replySegments, atSegments, textSegments, imageSegments []map[string]interface{}
 
 	for _, segment := range segments {
 		switch segment["type"] {
-		case "at":
+		case "reply":
+			replySegments = append(replySegments, segment)
+		case "at", "at_role":
 			atSegments = append(atSegments, segment)
 		case "text":
 			textSegments = append(textSegments, segment)
@@ -616,7 +764,7 @@ func sortMessageSegments(segments []map[string]interface{}) []map[string]interfa
 	}
 
 	// 按照指定的顺序合并这些切片
-	return append(append(atSegments, textSegments...), imageSegments...)
+	return append(append(append(replySegments, atSegments...), textSegments...), imageSegments...)
 }
 
 // SendMessage 发送消息根据不同的类型
@@ -796,3 +944,48 @@ func GetMessageTypeByGroupidV2(GroupID interface{}) string {
 	// }
 	return msgtype
 }
+
+// 通过group_id还原真实的频道id和所在的guild_id,用于群管理类action
+func resolveGroupGuild(groupID interface{}) (string, string, error) {
+	vGroupID := segmentValueToString(groupID)
+	if vGroupID == "" {
+		return "", "", fmt.Errorf("%w: group_id is empty", callapi.ErrInvalidParams)
+	}
+	channelID := vGroupID
+	if !config.GetStringOb11() {
+		var err error
+		channelID, err = idmap.RetrieveRowByIDv2(vGroupID)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: unknown group_id %v", callapi.ErrInvalidParams, vGroupID)
+		}
+	}
+	//读取ini 通过ChannelID取回之前储存的guild_id
+	guildID, _ := idmap.ReadConfigv2(channelID, "guild_id")
+	if guildID == "" {
+		guildID, _ = idmap.ReadConfigv2(vGroupID, "guild_id")
+	}
+	if guildID == "" {
+		return "", "", fmt.Errorf("%w: group_id %v is not a guild channel", callapi.ErrInvalidParams, vGroupID)
+	}
+	return channelID, guildID, nil
+}
+
+// 通过user_id还原真实的用户id
+func resolveUserID(userID interface{}) (string, error) {
+	vUserID := segmentValueToString(userID)
+	if vUserID == "" {
+		return "", fmt.Errorf("%w: user_id is empty", callapi.ErrInvalidParams)
+	}
+	// 机器人自身的user_id是app_id
+	if vUserID == AppID && BotID != "" {
+		return BotID, nil
+	}
+	if config.GetStringOb11() {
+		return vUserID, nil
+	}
+	realUserID, err := idmap.RetrieveRowByIDv2(vUserID)
+	if err != nil {
+		return "", fmt.Errorf("%w: unknown user_id %v", callapi.ErrInvalidParams, vUserID)
+	}
+	return realUserID, nil
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+)
+
+func TestParseMessageContentReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		message interface{}
+		text    string
+		replyID string
+	}{
+		{"cq code", "[CQ:reply,id=12]hello", "hello", "12"},
+		{"cq code with seq", "[CQ:reply,id=12,seq=3]hello", "hello", "12"},
+		{"segments with numeric id", []interface{}{
+			map[string]interface{}{"type": "reply", "data": map[string]interface{}{"id": float64(12)}},
+			map[string]interface{}{"type": "text", "data": map[string]interface{}{"text": "hello"}},
+		}, "hello", "12"},
+		{"single segment", map[string]interface{}{"type": "reply", "data": map[string]interface{}{"id": "34"}}, "", "34"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, foundItems := parseMessageContent(callapi.ParamsContent{Message: tt.message})
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if replies := foundItems["reply"]; len(replies) != 1 || replies[0] != tt.replyID {
+				t.Errorf("reply = %v, want [%s]", replies, tt.replyID)
+			}
+		})
+	}
+}
+
+func TestSegmentValueToString(t *testing.T) {
+	for value, want := range map[interface{}]string{
+		nil:                 "",
+		"abc":               "abc",
+		float64(1234567890): "1234567890",
+		int64(42):           "42",
+	} {
+		if got := segmentValueToString(value); got != want {
+			t.Errorf("segmentValueToString(%#v) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// 不是回复的消息不会读写数据库
+func TestReplyMessageIDWithoutReference(t *testing.T) {
+	messages := []*discordgo.Message{
+		{Type: discordgo.MessageTypeDefault, MessageReference: &discordgo.MessageReference{MessageID: "1"}},
+		{Type: discordgo.MessageTypeReply},
+		{Type: discordgo.MessageTypeReply, MessageReference: &discordgo.MessageReference{}},
+	}
+	for _, msg := range messages {
+		if got := replyMessageID(msg); got != "" {
+			t.Errorf("replyMessageID(%+v) = %q, want empty", msg, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+)
+
+// discord每次最多展示25个自动补全候选
+const maxAutocompleteChoices = 25
+
+var (
+	pendingAutocompletesMu sync.Mutex
+	// flag为自动补全交互的id
+	pendingAutocompletes = make(map[string]chan []*discordgo.ApplicationCommandOptionChoice)
+)
+
+func init() {
+	callapi.RegisterHandler("send_autocomplete_result", SendAutocompleteResult)
+}
+
+// 返回自动补全的候选 flag为autocomplete事件中的flag
+func SendAutocompleteResult(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	flag := message.Params.Flag
+	if flag == "" {
+		return "", fmt.Errorf("%w: flag is empty", callapi.ErrInvalidParams)
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, choice := range message.Params.Choices {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		name := choice.Name
+		if name == "" {
+			name = fmt.Sprint(choice.Value)
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: choice.Value,
+		})
+	}
+
+	pendingAutocompletesMu.Lock()
+	result, ok := pendingAutocompletes[flag]
+	delete(pendingAutocompletes, flag)
+	pendingAutocompletesMu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: unknown or expired flag %v", callapi.ErrInvalidParams, flag)
+	}
+	result <- choices
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
+
+// WaitAutocompleteResult 登记自动补全交互,返回的通道将收到应用端给出的候选
+// 超时后需调用CancelAutocompleteResult
+func WaitAutocompleteResult(flag string) <-chan []*discordgo.ApplicationCommandOptionChoice {
+	result := make(chan []*discordgo.ApplicationCommandOptionChoice, 1)
+	pendingAutocompletesMu.Lock()
+	pendingAutocompletes[flag] = result
+	pendingAutocompletesMu.Unlock()
+	return result
+}
+
+// CancelAutocompleteResult 放弃等待,之后到达的结果将被拒绝
+func CancelAutocompleteResult(flag string) {
+	pendingAutocompletesMu.Lock()
+	delete(pendingAutocompletes, flag)
+	pendingAutocompletesMu.Unlock()
+}
@@ -45,6 +45,8 @@ func HandleSendGroupMsg(client callapi.Client, s *discordgo.Session, message cal
 	} else if message.Params.GroupID != "" {
 		idInt64, err = ConvertToInt64(message.Params.GroupID)
 	}
+	// 枚举消息类型的递归调用中 无法识别的类型由后续的尝试处理,不回复失败
+	enumerating := echo.GetMapping(idInt64) > 0
 
 	//设置递归 对直接向gsk发送action时有效果
 	if msgType == "" {
@@ -93,7 +95,7 @@ func HandleSendGroupMsg(client callapi.Client, s *discordgo.Session, message cal
 		Vuserid, ok := message.Params.UserID.(string)
 		if !ok {
 			mylog.Printf("Error illegal UserID")
-			return "", nil
+			return callapi.SendFailedResponse(client, callapi.RetCodeBadRequest, "BAD_REQUEST", "user_id无效", message.Echo), nil
 		}
 		if Vuserid != "" && config.GetIdmapPro() {
 			RChannelID, _, err = idmap.RetrieveRowByIDv2Pro(message.Params.ChannelID, Vuserid)
@@ -109,12 +111,16 @@ func HandleSendGroupMsg(client callapi.Client, s *discordgo.Session, message cal
 		value, err := idmap.ReadConfigv2(RChannelID, "guild_id")
 		if err != nil {
 			mylog.Printf("Error reading config: %v", err)
-			return "", nil
+			return callapi.SendFailedResponse(client, callapi.RetCodeBadRequest, "BAD_REQUEST", "无法找到group_id对应的频道", message.Echo), nil
 		}
 		retmsg, _ = HandleSendGuildChannelPrivateMsg(client, s, message, &value, &RChannelID)
 
 	default:
 		mylog.Printf("Unknown message type: %s", msgType)
+		// msgType为空且id有效时 上面的递归调用已经回复
+		if (msgType != "" && !enumerating) || (msgType == "" && err != nil) {
+			retmsg = callapi.SendFailedResponse(client, callapi.RetCodeBadRequest, "UNKNOWN_MESSAGE_TYPE", "无法确定group_id对应的消息类型", message.Echo)
+		}
 	}
 	//重置递归类型
 	if echo.GetMapping(idInt64) <= 0 {
@@ -15,6 +15,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/config"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 
 	"github.com/hoshinonyaruko/gensokyo-discord/echo"
@@ -88,17 +89,26 @@ func HandleSendGuildChannelMsg(client callapi.Client, s *discordgo.Session, mess
 		replyMsg, err := GenerateReplyMessage(foundItems, messageText)
 		if err != nil {
 			mylog.Printf("生成消息失败: %v", err)
-			return "", err
+			retmsg, _ := SendResponse(client, err, &message, 0)
+			return retmsg, nil
+		}
+		if channelID == "" {
+			retmsg, _ := SendResponse(client, fmt.Errorf("%w: channel_id is empty", callapi.ErrInvalidParams), &message, 0)
+			return retmsg, nil
 		}
 		mylog.Printf("频道发信息channelID:%v  replyMsg:%v", channelID, replyMsg)
-		_, err = s.ChannelMessageSendComplex(channelID, replyMsg)
+		sent, err := sendChannelMessage(s, channelID, replyMsg, replyTarget(replyMsg, message.Echo))
+		var sentID int
 		if err != nil {
 			mylog.Printf("发送消息失败: %v", err)
-			return "", err
+			metrics.AddSendFailed()
+		} else {
+			metrics.AddMessageSent()
+			sentID = StoreMessageID(sent.ID, sent.ChannelID)
 		}
 
 		// 发送回执
-		retmsg, _ := SendResponse(client, err, &message)
+		retmsg, _ := SendResponse(client, err, &message, sentID)
 		return retmsg, nil
 	//频道私信 此时直接取出
 	case "guild_private":
@@ -125,6 +135,22 @@ func GenerateReplyMessage(foundItems map[string][]string, messageText string) (*
 		Content: messageText,
 	}
 
+	// 处理回复 discord只能引用一条消息,取第一个
+	if replyIDs := foundItems["reply"]; len(replyIDs) > 0 {
+		messageID, channelID, err := resolveMessageID(replyIDs[0])
+		if err != nil {
+			log.Printf("无法还原回复的消息：%v", err)
+		} else {
+			// 被引用的消息已删除时仍然正常发送
+			failIfNotExists := false
+			msg.Reference = &discordgo.MessageReference{
+				MessageID:       messageID,
+				ChannelID:       channelID,
+				FailIfNotExists: &failIfNotExists,
+			}
+		}
+	}
+
 	// 处理本地图片
 	if imageURLs, ok := foundItems["local_image"]; ok {
 		for _, imageURL := range imageURLs {
@@ -208,6 +234,45 @@ func GenerateReplyMessage(foundItems map[string][]string, messageText string) (*
 			})
 		}
 	}
+	// 处理语音
+	for _, source := range mediaSources(foundItems, "record") {
+		data, _, err := readMediaSource(source)
+		if err != nil {
+			log.Printf("无法读取语音：%v", err)
+			continue
+		}
+		msg.Files = append(msg.Files, newRecordFile(data))
+	}
+
+	// 处理视频
+	for _, source := range mediaSources(foundItems, "video") {
+		data, _, err := readMediaSource(source)
+		if err != nil {
+			log.Printf("无法读取视频：%v", err)
+			continue
+		}
+		msg.Files = append(msg.Files, newMediaFile(data, "video", ""))
+	}
+
+	// 处理文件 优先使用name指定的文件名
+	fileNames := foundItems["file_name"]
+	for i, source := range foundItems["file"] {
+		data, name, err := readMediaSource(source)
+		if err != nil {
+			log.Printf("无法读取文件：%v", err)
+			continue
+		}
+		if i < len(fileNames) && fileNames[i] != "" {
+			name = fileNames[i]
+		}
+		msg.Files = append(msg.Files, newMediaFile(data, "file", name))
+	}
+
+	// 处理qq音乐
+	for _, songID := range foundItems["qqmusic"] {
+		msg.Embeds = append(msg.Embeds, newQQMusicEmbed(songID))
+	}
+
 	// 处理Base64编码的markdown
 	if markdowns, ok := foundItems["markdown"]; ok {
 		for _, markdown := range markdowns {
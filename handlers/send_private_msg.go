@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -8,6 +9,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/config"
 	"github.com/hoshinonyaruko/gensokyo-discord/echo"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
@@ -133,7 +135,8 @@ func HandleSendGuildChannelPrivateMsg(client callapi.Client, s *discordgo.Sessio
 			UserID, err = idmap.RetrieveRowByIDv2(RawUserID)
 			if err != nil {
 				mylog.Printf("Error reading config: %v", err)
-				return "", nil
+				retmsg, _ = SendResponse(client, fmt.Errorf("%w: unknown user_id %v", callapi.ErrInvalidParams, RawUserID), &message, 0)
+				return retmsg, nil
 			}
 		}
 		// 如果messageID为空，通过函数获取
@@ -179,24 +182,30 @@ func HandleSendGuildChannelPrivateMsg(client callapi.Client, s *discordgo.Sessio
 	dmChannel, err := s.UserChannelCreate(UserID)
 	if err != nil {
 		mylog.Printf("创建私信频道失败: %v", err)
-		return "", nil
+		retmsg, _ = SendResponse(client, err, &message, 0)
+		return retmsg, nil
 	}
 
 	// 使用GenerateReplyMessage函数处理所有类型的消息
 	combinedMsg, err := GenerateReplyMessage(foundItems, messageText)
 	if err != nil {
 		mylog.Printf("生成消息失败: %v", err)
-		return "", nil
+		retmsg, _ = SendResponse(client, err, &message, 0)
+		return retmsg, nil
 	}
 
 	// 向私信频道发送消息
-	_, err = s.ChannelMessageSendComplex(dmChannel.ID, combinedMsg)
+	sent, err := sendChannelMessage(s, dmChannel.ID, combinedMsg, replyTarget(combinedMsg, message.Echo))
+	var sentID int
 	if err != nil {
 		mylog.Printf("发送私信失败: %v", err)
-		return "", nil
+		metrics.AddSendFailed()
+	} else {
+		metrics.AddMessageSent()
+		sentID = StoreMessageID(sent.ID, sent.ChannelID)
 	}
 
 	// 发送回执
-	retmsg, _ = SendResponse(client, err, &message)
+	retmsg, _ = SendResponse(client, err, &message, sentID)
 	return retmsg, nil
 }
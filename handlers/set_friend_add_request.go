@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+)
+
+func init() {
+	callapi.RegisterHandler("set_friend_add_request", SetFriendAddRequest)
+}
+
+// discord的机器人账号无法收到好友请求,不会上报friend类型的请求事件
+func SetFriendAddRequest(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	return "", errors.New("discord机器人没有好友请求")
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_add_request", SetGroupAddRequest)
+}
+
+// 处理加群请求 即开启了成员审核的服务器中的待审核成员
+// 同意时授予request_approve_role身份组,拒绝时踢出
+func SetGroupAddRequest(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	flag := message.Params.Flag
+	if flag == "" {
+		return "", fmt.Errorf("%w: flag is empty", callapi.ErrInvalidParams)
+	}
+	guildID, _ := idmap.ReadConfigv2(flag, "guild_id")
+	userID, _ := idmap.ReadConfigv2(flag, "user_id")
+	if guildID == "" || userID == "" || GroupAddRequestFlag(guildID, userID) != flag {
+		return "", fmt.Errorf("%w: unknown or expired flag %v", callapi.ErrInvalidParams, flag)
+	}
+
+	// 未指定approve时默认同意
+	approve := message.Params.Approve == nil || *message.Params.Approve
+	var err error
+	if approve {
+		err = approveGuildMember(s, guildID, userID)
+	} else {
+		var options []discordgo.RequestOption
+		if message.Params.Reason != "" {
+			options = append(options, discordgo.WithAuditLogReason(message.Params.Reason))
+		}
+		err = s.GuildMemberDelete(guildID, userID, options...)
+	}
+	if err != nil {
+		mylog.Printf("处理加群请求失败: %v", err)
+		return "", err
+	}
+	if !approve {
+		ClearGroupAddRequest(guildID, userID)
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
+
+// 授予身份组即可跳过成员审核 bot无法替成员完成审核,必须配置身份组
+func approveGuildMember(s *discordgo.Session, guildID string, userID string) error {
+	roleID := config.GetRequestApproveRole()
+	if roleID == "" {
+		return errors.New("request_approve_role is not configured, cannot approve member screening")
+	}
+	return s.GuildMemberRoleAdd(guildID, userID, roleID)
+}
+
+// StoreGroupAddRequest 为待审核成员生成flag并写入数据库,重启后仍可处理
+func StoreGroupAddRequest(guildID string, userID string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	flag := hex.EncodeToString(b)
+	if err := idmap.WriteConfigv2(flag, "guild_id", guildID); err != nil {
+		return "", err
+	}
+	if err := idmap.WriteConfigv2(flag, "user_id", userID); err != nil {
+		return "", err
+	}
+	if err := idmap.WriteConfigv2(groupAddRequestKey(guildID, userID), "request_flag", flag); err != nil {
+		return "", err
+	}
+	return flag, nil
+}
+
+// GroupAddRequestFlag 获取成员尚未处理的加群请求flag,没有时返回空
+func GroupAddRequestFlag(guildID string, userID string) string {
+	flag, _ := idmap.ReadConfigv2(groupAddRequestKey(guildID, userID), "request_flag")
+	return flag
+}
+
+// ClearGroupAddRequest 成员通过审核、被拒绝或离开后,删除flag
+func ClearGroupAddRequest(guildID string, userID string) {
+	flag := GroupAddRequestFlag(guildID, userID)
+	if flag == "" {
+		return
+	}
+	for _, key := range [][2]string{
+		{groupAddRequestKey(guildID, userID), "request_flag"},
+		{flag, "guild_id"},
+		{flag, "user_id"},
+	} {
+		if err := idmap.DeleteConfigv2(key[0], key[1]); err != nil {
+			mylog.Printf("Error deleting config: %v", err)
+		}
+	}
+}
+
+func groupAddRequestKey(guildID string, userID string) string {
+	return guildID + "_" + userID
+}
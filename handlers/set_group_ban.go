@@ -1,87 +1,59 @@
 package handlers
 
 import (
-	"log"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
-	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
+// discord的禁言(timeout)最长28天
+const maxTimeoutDuration = 28 * 24 * time.Hour
+
+// 未指定禁言时长时的默认值 单位秒 与onebotv11一致为30分钟
+const defaultBanDuration = 30 * 60
+
 func init() {
-	callapi.RegisterHandler("get_group_ban", SetGroupBan)
+	callapi.RegisterHandler("set_group_ban", SetGroupBan)
 }
 
 func SetGroupBan(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
-
-	// 从message中获取group_id和UserID
-	groupID := message.Params.GroupID.(string)
-	receivedUserID := message.Params.UserID.(string)
-	//读取ini 通过ChannelID取回之前储存的guild_id
-	guildID, err := idmap.ReadConfigv2(groupID, "guild_id")
+	_, guildID, err := resolveGroupGuild(message.Params.GroupID)
 	if err != nil {
-		mylog.Printf("Error reading config: %v", err)
-		return "", nil
+		return "", err
 	}
-	// 根据UserID读取真实的userid
-	realUserID, err := idmap.RetrieveRowByIDv2(receivedUserID)
+	realUserID, err := resolveUserID(message.Params.UserID)
 	if err != nil {
-		mylog.Printf("Error reading real userID: %v", err)
-		return "", nil
+		return "", err
 	}
 
-	// 读取消息类型
-	msgType, err := idmap.ReadConfigv2(groupID, "type")
+	duration := defaultBanDuration
+	if message.Params.Duration != nil {
+		duration = *message.Params.Duration
+	}
+	err = timeoutMember(s, guildID, realUserID, duration)
 	if err != nil {
-		mylog.Printf("Error reading config for message type: %v", err)
-		return "", nil
+		mylog.Printf("设置禁言失败: %v", err)
+		return "", err
 	}
 
-	// 根据消息类型进行操作
-	switch msgType {
-	case "group":
-		mylog.Printf("setGroupBan(频道): 目前暂未开放该能力")
-		return "", nil
-	case "private":
-		mylog.Printf("setGroupBan(频道): 目前暂未适配私聊虚拟群场景的禁言能力")
-		return "", nil
-	case "guild":
-		// 假设 roleID 是无发言权限的角色ID
-		// todo 完善这里
-		roleID := "特定的角色ID"
-
-		// 将字符串 duration 转换为整数
-		duration := message.Params.Duration
-		if err != nil {
-			log.Printf("Error converting duration: %v", err)
-			return "", nil
-		}
-
-		if duration == 0 {
-			// 解除禁言
-			err = s.GuildMemberRoleRemove(guildID, realUserID, roleID)
-			if err != nil {
-				log.Printf("Error removing mute role from member: %v", err)
-			}
-		} else {
-			// 禁言用户
-			err = s.GuildMemberRoleAdd(guildID, realUserID, roleID)
-			if err != nil {
-				log.Printf("Error adding mute role to member: %v", err)
-				return "", nil
-			}
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
 
-			// 设置一个定时器，在指定的时间后解除禁言
-			time.AfterFunc(time.Duration(duration)*time.Second, func() {
-				err := s.GuildMemberRoleRemove(guildID, realUserID, roleID)
-				if err != nil {
-					log.Printf("Error automatically unmuting member: %v", err)
-				}
-			})
+// 使用discord原生的timeout,到期由discord解除,重启后不会丢失
+// seconds为0时解除禁言
+func timeoutMember(s *discordgo.Session, guildID string, userID string, seconds int) error {
+	var until *time.Time
+	if seconds > 0 {
+		duration := time.Duration(seconds) * time.Second
+		if duration > maxTimeoutDuration {
+			mylog.Printf("禁言时长%v超过discord上限,按28天处理", duration)
+			duration = maxTimeoutDuration
 		}
+		t := time.Now().Add(duration)
+		until = &t
 	}
-
-	return "", nil
+	return s.GuildMemberTimeout(guildID, userID, until)
 }
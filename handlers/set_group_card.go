@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_card", SetGroupCard)
+}
+
+// 设置群名片 对应discord的服务器昵称,card为空时恢复为用户名
+func SetGroupCard(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	_, guildID, err := resolveGroupGuild(message.Params.GroupID)
+	if err != nil {
+		return "", err
+	}
+	realUserID, err := resolveUserID(message.Params.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	// 修改机器人自己的昵称需要使用@me
+	if s.State != nil && s.State.User != nil && realUserID == s.State.User.ID {
+		realUserID = "@me"
+	}
+
+	err = s.GuildMemberNickname(guildID, realUserID, message.Params.Card)
+	if err != nil {
+		mylog.Printf("设置群名片失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
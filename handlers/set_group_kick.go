@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_kick", SetGroupKick)
+}
+
+// 踢出成员 reject_add_request为true时使用封禁,使其无法再次加入
+func SetGroupKick(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	_, guildID, err := resolveGroupGuild(message.Params.GroupID)
+	if err != nil {
+		return "", err
+	}
+	realUserID, err := resolveUserID(message.Params.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	err = kickMember(s, guildID, realUserID, message.Params.RejectAddRequest)
+	if err != nil {
+		mylog.Printf("踢出成员失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
+
+// 踢出成员 reject为true时使用封禁
+func kickMember(s *discordgo.Session, guildID string, userID string, reject bool) error {
+	if reject {
+		return s.GuildBanCreate(guildID, userID, 0)
+	}
+	return s.GuildMemberDelete(guildID, userID)
+}
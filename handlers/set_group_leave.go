@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_leave", SetGroupLeave)
+}
+
+// 退出群 群是由子频道虚拟的,退出的是子频道所在的整个guild
+func SetGroupLeave(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	_, guildID, err := resolveGroupGuild(message.Params.GroupID)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.GuildLeave(guildID)
+	if err != nil {
+		mylog.Printf("退出群失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
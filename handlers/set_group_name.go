@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_name", SetGroupName)
+}
+
+// 设置群名 修改群所对应的子频道名称
+func SetGroupName(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	if message.Params.GroupName == "" {
+		return "", fmt.Errorf("%w: group_name is empty", callapi.ErrInvalidParams)
+	}
+	channelID, _, err := resolveGroupGuild(message.Params.GroupID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.ChannelEdit(channelID, &discordgo.ChannelEdit{
+		Name: message.Params.GroupName,
+	})
+	if err != nil {
+		mylog.Printf("设置群名失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
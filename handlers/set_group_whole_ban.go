@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_group_whole_ban", SetGroupWholeBan)
+}
+
+// 全员禁言 通过频道上@everyone的权限覆盖切换发送消息权限
+func SetGroupWholeBan(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	channelID, guildID, err := resolveGroupGuild(message.Params.GroupID)
+	if err != nil {
+		return "", err
+	}
+
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+		if err != nil {
+			mylog.Printf("获取频道信息失败: %v", err)
+			return "", err
+		}
+	}
+
+	// 保留@everyone覆盖中的其他权限 @everyone身份组的id与guild_id相同
+	var allow, deny int64
+	for _, overwrite := range channel.PermissionOverwrites {
+		if overwrite.ID == guildID && overwrite.Type == discordgo.PermissionOverwriteTypeRole {
+			allow = overwrite.Allow
+			deny = overwrite.Deny
+			break
+		}
+	}
+	// 未指定enable时默认开启全员禁言
+	if message.Params.Enable == nil || *message.Params.Enable {
+		allow &^= discordgo.PermissionSendMessages
+		deny |= discordgo.PermissionSendMessages
+	} else {
+		deny &^= discordgo.PermissionSendMessages
+	}
+
+	err = s.ChannelPermissionSet(channelID, guildID, discordgo.PermissionOverwriteTypeRole, allow, deny)
+	if err != nil {
+		mylog.Printf("设置全员禁言失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+func init() {
+	callapi.RegisterHandler("set_msg_emoji_like", SetMsgEmojiLike)
+	callapi.RegisterHandler("delete_msg_emoji_like", DeleteMsgEmojiLike)
+}
+
+// 对消息添加表情回应 set为false时取消回应
+func SetMsgEmojiLike(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	set := message.Params.Set == nil || *message.Params.Set
+	return handleMsgEmojiLike(client, s, message, set)
+}
+
+// 取消机器人对消息的表情回应
+func DeleteMsgEmojiLike(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage) (string, error) {
+	return handleMsgEmojiLike(client, s, message, false)
+}
+
+func handleMsgEmojiLike(client callapi.Client, s *discordgo.Session, message callapi.ActionMessage, set bool) (string, error) {
+	messageID, channelID, err := resolveMessageID(message.Params.MessageID)
+	if err != nil {
+		return "", err
+	}
+	rawEmoji := segmentValueToString(message.Params.EmojiID)
+	if rawEmoji == "" {
+		return "", fmt.Errorf("%w: emoji_id is empty", callapi.ErrInvalidParams)
+	}
+	emoji := resolveReactionEmoji(s, channelID, rawEmoji)
+
+	if set {
+		err = s.MessageReactionAdd(channelID, messageID, emoji)
+	} else {
+		err = s.MessageReactionRemove(channelID, messageID, emoji, "@me")
+	}
+	if err != nil {
+		mylog.Printf("设置表情回应失败: %v", err)
+		return "", err
+	}
+
+	retmsg, _ := SendResponse(client, nil, &message, 0)
+	return retmsg, nil
+}
+
+// 将emoji_id转换为discord接口需要的格式
+// 支持unicode表情、<:name:id>、name:id和上报中的纯数字自定义表情id
+func resolveReactionEmoji(s *discordgo.Session, channelID string, raw string) string {
+	if submatches := customEmojiPattern.FindStringSubmatch(raw); submatches != nil {
+		return submatches[2] + ":" + submatches[3]
+	}
+	if strings.Contains(raw, ":") || !isDigits(raw) {
+		return raw
+	}
+
+	// 纯数字为自定义表情id,从所在服务器的表情中找出名字
+	if channel, err := s.State.Channel(channelID); err == nil && channel.GuildID != "" {
+		if guild, err := s.State.Guild(channel.GuildID); err == nil {
+			for _, emoji := range guild.Emojis {
+				if emoji.ID == raw {
+					return emoji.APIName()
+				}
+			}
+		}
+	}
+	// discord只校验表情id
+	return "_:" + raw
+}
+
+func isDigits(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, r := range str {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
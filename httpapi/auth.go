@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 频率限制的统计周期
+const RequestInterval = time.Minute
+
+// AuthMiddleware 校验http_access_token
+// 未提供token返回401,token错误返回403,与onebotv11一致
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		validToken := config.GetHttpAccessToken()
+		if validToken == "" {
+			c.Next()
+			return
+		}
+
+		var token string
+		if tokenFromHeader := c.GetHeader("Authorization"); tokenFromHeader != "" {
+			token = headerToken(tokenFromHeader)
+		} else {
+			token = c.Query("access_token")
+		}
+
+		if token == "" {
+			mylog.Printf("http api请求缺少access_token,ip:%s", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, callapi.BuildFailedResponse(callapi.RetCodeUnauthorized, "UNAUTHORIZED", "缺少access_token", nil))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(validToken)) != 1 {
+			mylog.Printf("http api请求的access_token错误,ip:%s", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusForbidden, callapi.BuildFailedResponse(callapi.RetCodeForbidden, "FORBIDDEN", "access_token错误", nil))
+			return
+		}
+		c.Next()
+	}
+}
+
+// 从Authorization头中取出token 支持Bearer <token>和Token <token>,没有前缀时整体作为token
+func headerToken(header string) string {
+	if scheme, token, ok := strings.Cut(header, " "); ok {
+		if strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "Token") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return header
+}
+
+// RateLimiter 按ip统计一分钟内的请求次数
+type RateLimiter struct {
+	mu        sync.Mutex
+	Counts    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// 频率限制器
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		Counts: make(map[string][]time.Time),
+	}
+}
+
+// RateLimitMiddleware 超过http_rate_limit的请求返回429
+func RateLimitMiddleware(rateLimiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ipAddress := c.ClientIP()
+		if !rateLimiter.CheckAndUpdateRateLimit(ipAddress) {
+			mylog.Printf("http api请求过于频繁,ip:%s", ipAddress)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, callapi.BuildFailedResponse(callapi.RetCodeTooManyRequests, "TOO_MANY_REQUESTS", "请求过于频繁", nil))
+			return
+		}
+		c.Next()
+	}
+}
+
+// 检查是否超过调用频率限制
+func (rl *RateLimiter) CheckAndUpdateRateLimit(ipAddress string) bool {
+	maxRequests := config.GetHttpRateLimit()
+	if maxRequests <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	// 每个统计周期清理一次所有ip的过期记录,避免长期运行时map无限增长
+	if now.Sub(rl.lastSweep) > RequestInterval {
+		for ip, times := range rl.Counts {
+			if len(pruneRequestTimes(times, now)) == 0 {
+				delete(rl.Counts, ip)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	times := pruneRequestTimes(rl.Counts[ipAddress], now)
+	if len(times) >= maxRequests {
+		rl.Counts[ipAddress] = times
+		return false
+	}
+	rl.Counts[ipAddress] = append(times, now)
+	return true
+}
+
+// 去掉统计周期之前的请求记录
+func pruneRequestTimes(times []time.Time, now time.Time) []time.Time {
+	for len(times) > 0 && now.Sub(times[0]) > RequestInterval {
+		times = times[1:]
+	}
+	return times
+}
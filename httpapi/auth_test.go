@@ -0,0 +1,17 @@
+package httpapi
+
+import "testing"
+
+func TestHeaderToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc": "abc",
+		"Token abc":  "abc",
+		"token abc":  "abc",
+		"abc":        "abc",
+		"Basic abc":  "Basic abc",
+	} {
+		if got := headerToken(header); got != want {
+			t.Errorf("headerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -1,198 +1,215 @@
 package httpapi
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/gin-gonic/gin"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
-	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
+// 以_async结尾的action不等待执行结果
+const asyncSuffix = "_async"
+
+// ParamsContent中各参数的类型,用于转换查询参数和表单中的字符串
+var paramKinds = buildParamKinds()
+
 // CombinedMiddleware 创建并返回一个带有依赖的中间件闭包
+// 路径即为action名,所有通过callapi.RegisterHandler注册的action都可以调用
 func CombinedMiddleware(s *discordgo.Session) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 检查路径和处理对应的请求
-		fmt.Printf("s2:%v", s)
-		if c.Request.URL.Path == "/send_group_msg" {
-			handleSendGroupMessage(c, s)
+		action := strings.Trim(c.Request.URL.Path, "/")
+		if action == "" {
+			c.Next()
 			return
 		}
-		if c.Request.URL.Path == "/send_private_msg" {
-			handleSendPrivateMessage(c, s)
+
+		params, err := parseParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, callapi.BuildFailedResponse(callapi.RetCodeBadRequest, "BAD_REQUEST", err.Error(), nil))
 			return
 		}
-		if c.Request.URL.Path == "/send_guild_channel_msg" {
-			handleSendGuildChannelMessage(c, s)
+
+		async := strings.HasSuffix(action, asyncSuffix)
+		if async {
+			action = strings.TrimSuffix(action, asyncSuffix)
+		}
+
+		message, err := buildActionMessage(action, params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, callapi.BuildFailedResponse(callapi.RetCodeBadRequest, "BAD_REQUEST", err.Error(), nil))
 			return
 		}
 
-		// 调用c.Next()以继续处理请求链
-		c.Next()
-	}
-}
+		if async {
+			go callapi.CallAPIFromDict(&HttpAPIClient{}, s, message)
+			c.JSON(http.StatusOK, gin.H{
+				"status":  "async",
+				"retcode": callapi.RetCodeAsync,
+				"data":    nil,
+			})
+			return
+		}
 
-// handleSendGroupMessage 处理发送群聊消息的请求
-func handleSendGroupMessage(c *gin.Context, s *discordgo.Session) {
-	var retmsg string
-	var req struct {
-		GroupID    int64  `json:"group_id" form:"group_id"`
-		Message    string `json:"message" form:"message"`
-		AutoEscape bool   `json:"auto_escape" form:"auto_escape"`
-	}
+		client := &HttpAPIClient{}
+		retmsg := callapi.CallAPIFromDict(client, s, message)
+		if retmsg == "" {
+			// 部分handler只通过client回复
+			retmsg = client.Response()
+		}
 
-	// 根据请求方法解析参数
-	if c.Request.Method == http.MethodGet {
-		// 从URL查询参数解析
-		if err := c.ShouldBindQuery(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		status := http.StatusOK
+		var result struct {
+			RetCode int `json:"retcode"`
 		}
-	} else {
-		// 从JSON或表单数据解析
-		if err := c.ShouldBind(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		if json.Unmarshal([]byte(retmsg), &result) == nil && result.RetCode == callapi.RetCodeNotFound {
+			status = http.StatusNotFound
 		}
-	}
 
-	// 使用解析后的参数处理请求
-	// TODO: 添加请求处理逻辑
-	// 例如：api.SendGroupMessage(req.GroupID, req.Message, req.AutoEscape)
-	client := &HttpAPIClient{}
-	// 创建 ActionMessage 实例
-	message := callapi.ActionMessage{
-		Action: "send_group_msg",
-		Params: callapi.ParamsContent{
-			GroupID: strconv.FormatInt(req.GroupID, 10), // 注意这里需要转换类型，因为 GroupID 是 int64
-			Message: req.Message,
-		},
-	}
-	// 调用处理函数
-	retmsg, err := handlers.HandleSendGroupMsg(client, s, message)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		// 返回处理结果
+		c.Header("Content-Type", "application/json")
+		c.String(status, retmsg)
 	}
-
-	// 返回处理结果
-	c.Header("Content-Type", "application/json")
-	c.String(http.StatusOK, retmsg)
 }
 
-// handleSendPrivateMessage 处理发送私聊消息的请求
-func handleSendPrivateMessage(c *gin.Context, s *discordgo.Session) {
-	var retmsg string
-	var req struct {
-		GroupID    int64  `json:"group_id" form:"group_id"`
-		UserID     int64  `json:"user_id" form:"user_id"`
-		Message    string `json:"message" form:"message"`
-		AutoEscape bool   `json:"auto_escape" form:"auto_escape"`
+// 依次从查询参数、表单和json请求体中读取参数,后者覆盖前者
+func parseParams(c *gin.Context) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	for key, values := range c.Request.URL.Query() {
+		params[key] = convertParam(key, values[len(values)-1])
 	}
 
-	// 根据请求方法解析参数
-	if c.Request.Method == http.MethodGet {
-		// 从URL查询参数解析
-		if err := c.ShouldBindQuery(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-	} else {
-		// 从JSON或表单数据解析
-		if err := c.ShouldBind(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
+	if c.Request.Method == http.MethodGet || c.Request.Body == nil {
+		return params, nil
 	}
 
-	// 使用解析后的参数处理请求
-	// TODO: 添加请求处理逻辑
-	// 例如：api.SendGroupMessage(req.GroupID, req.Message, req.AutoEscape)
-	client := &HttpAPIClient{}
-	// 创建 ActionMessage 实例
-	message := callapi.ActionMessage{
-		Action: "send_private_msg",
-		Params: callapi.ParamsContent{
-			GroupID: strconv.FormatInt(req.GroupID, 10), // 注意这里需要转换类型，因为 GroupID 是 int64
-			UserID:  strconv.FormatInt(req.UserID, 10),
-			Message: req.Message,
-		},
-	}
-	// 调用处理函数
-	retmsg, err := handlers.HandleSendPrivateMsg(client, s, message)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	contentType := c.ContentType()
+	switch {
+	case contentType == gin.MIMEJSON || contentType == "":
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(body))) == 0 {
+			return params, nil
+		}
+		var jsonParams map[string]interface{}
+		if err := json.Unmarshal(body, &jsonParams); err != nil {
+			return nil, fmt.Errorf("invalid json body: %v", err)
+		}
+		for key, value := range jsonParams {
+			params[key] = value
+		}
+	case contentType == gin.MIMEPOSTForm || contentType == gin.MIMEMultipartPOSTForm:
+		if contentType == gin.MIMEMultipartPOSTForm {
+			if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+				return nil, err
+			}
+		} else if err := c.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		for key, values := range c.Request.PostForm {
+			params[key] = convertParam(key, values[len(values)-1])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type: %s", contentType)
 	}
-
-	// 返回处理结果
-	c.Header("Content-Type", "application/json")
-	c.String(http.StatusOK, retmsg)
+	return params, nil
 }
 
-// handleSendGuildChannelMessage 处理发送消频道息的请求
-func handleSendGuildChannelMessage(c *gin.Context, s *discordgo.Session) {
-	var retmsg string
-	var req struct {
-		GuildID    string `json:"guild_id" form:"guild_id"`
-		ChannelID  string `json:"channel_id" form:"channel_id"`
-		Message    string `json:"message" form:"message"`
-		AutoEscape bool   `json:"auto_escape" form:"auto_escape"`
-	}
-
-	// 根据请求方法解析参数
-	if c.Request.Method == http.MethodGet {
-		// 从URL查询参数解析
-		if err := c.ShouldBindQuery(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+// 按ParamsContent中的字段类型转换字符串参数,无法转换时保留原值交给handler判断
+func convertParam(key string, value string) interface{} {
+	switch paramKinds[key] {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
 		}
-	} else {
-		// 从JSON或表单数据解析
-		if err := c.ShouldBind(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err == nil {
+			return v
 		}
 	}
+	return value
+}
 
-	// 使用解析后的参数处理请求
-	// TODO: 添加请求处理逻辑
-	// 例如：api.SendGroupMessage(req.GroupID, req.Message, req.AutoEscape)
-	client := &HttpAPIClient{}
-	// 创建 ActionMessage 实例
-	message := callapi.ActionMessage{
-		Action: "send_guild_channel_msg",
-		Params: callapi.ParamsContent{
-			GuildID:   req.GuildID,
-			ChannelID: req.ChannelID, // 注意这里需要转换类型，因为 GroupID 是 int64
-			Message:   req.Message,
-		},
-	}
-	// 调用处理函数
-	retmsg, err := handlers.HandleSendGuildChannelMsg(client, s, message)
+// 通过json编解码构造ActionMessage,复用ParamsContent对id类型的兼容处理
+func buildActionMessage(action string, params map[string]interface{}) (callapi.ActionMessage, error) {
+	var message callapi.ActionMessage
+	data, err := json.Marshal(map[string]interface{}{
+		"action": action,
+		"params": params,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return message, err
 	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("invalid params: %v", err)
+	}
+	return message, nil
+}
 
-	// 返回处理结果
-	c.Header("Content-Type", "application/json")
-	c.String(http.StatusOK, retmsg)
+func buildParamKinds() map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind)
+	t := reflect.TypeOf(callapi.ParamsContent{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		kinds[name] = fieldType.Kind()
+	}
+	return kinds
 }
 
 // 定义了一个符合 Client 接口的 HttpAPIClient 结构体
+// 保存handler通过client发送的最后一条回复
 type HttpAPIClient struct {
-	// 可添加所需字段
+	mu       sync.Mutex
+	response map[string]interface{}
 }
 
 // 实现 Client 接口的 SendMessage 方法
-// 假client中不执行任何操作，只是返回 nil 来符合接口要求
 func (c *HttpAPIClient) SendMessage(message map[string]interface{}) error {
-	// 不实际发送消息
-	// log.Printf("SendMessage called with: %v", message)
-
-	// 返回nil占位符
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.response = message
 	return nil
 }
+
+// Response 返回handler通过client发送的回复,没有回复时视为成功
+func (c *HttpAPIClient) Response() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response := c.response
+	if response == nil {
+		response = map[string]interface{}{
+			"status":  "ok",
+			"retcode": callapi.RetCodeOK,
+			"data":    nil,
+		}
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		mylog.Printf("Error marshaling data: %v", err)
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConvertParam(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  interface{}
+	}{
+		{"duration", "60", int64(60)},
+		{"duration", "abc", "abc"},
+		{"enable", "false", false},
+		{"reject_add_request", "true", true},
+		{"reject_add_request", "yes", "yes"},
+		// interface{}类型的id保留字符串,由ParamsContent自行兼容
+		{"group_id", "123", "123"},
+		{"card", "456", "456"},
+		{"operation", `{"reply":"hi"}`, map[string]interface{}{"reply": "hi"}},
+		{"operation", "hi", "hi"},
+		{"foo", "1", "1"},
+	}
+	for _, tt := range tests {
+		if got := convertParam(tt.key, tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertParam(%q, %q) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+// 构造请求并解析参数
+func parseRequest(t *testing.T, method, target, contentType, body string) (map[string]interface{}, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	return parseParams(c)
+}
+
+func TestParseParams(t *testing.T) {
+	params, err := parseRequest(t, http.MethodGet, "/send_group_msg?group_id=123&message=hi", "", "")
+	if err != nil || !reflect.DeepEqual(params, map[string]interface{}{"group_id": "123", "message": "hi"}) {
+		t.Errorf("query: params = %v, err = %v", params, err)
+	}
+
+	// body中的参数覆盖query
+	params, err = parseRequest(t, http.MethodPost, "/set_group_ban?group_id=1&duration=10", "application/json", `{"group_id":123,"user_id":"456"}`)
+	want := map[string]interface{}{"group_id": float64(123), "user_id": "456", "duration": int64(10)}
+	if err != nil || !reflect.DeepEqual(params, want) {
+		t.Errorf("json body: params = %v, err = %v, want %v", params, err, want)
+	}
+
+	params, err = parseRequest(t, http.MethodPost, "/get_status", "", `{"echo":"a"}`)
+	if err != nil || params["echo"] != "a" {
+		t.Errorf("json without content type: params = %v, err = %v", params, err)
+	}
+
+	params, err = parseRequest(t, http.MethodPost, "/get_status?no_cache=true", "application/json", "")
+	if err != nil || len(params) != 1 || params["no_cache"] != "true" {
+		t.Errorf("empty body: params = %v, err = %v", params, err)
+	}
+
+	params, err = parseRequest(t, http.MethodPost, "/set_group_whole_ban", "application/x-www-form-urlencoded", "group_id=1&enable=false")
+	if err != nil || params["enable"] != false || params["group_id"] != "1" {
+		t.Errorf("form: params = %v, err = %v", params, err)
+	}
+
+	if _, err := parseRequest(t, http.MethodPost, "/get_status", "application/json", "{"); err == nil {
+		t.Error("invalid json body should return an error")
+	}
+	if _, err := parseRequest(t, http.MethodPost, "/get_status", "text/plain", "hi"); err == nil {
+		t.Error("unsupported content type should return an error")
+	}
+}
@@ -0,0 +1,129 @@
+package idmap
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/boltdb/bolt"
+)
+
+// QueueBucket 反向ws断线期间暂存的事件 每个连接一个子bucket,key为递增序号
+// 队列长度记录在QueueBucket中 key为队列名+queueCountSuffix
+const QueueBucket = "queue"
+
+const queueCountSuffix = "#count"
+
+var ErrDBNotReady = errors.New("database is not initialized")
+
+// QueuePush 在队列末尾追加一条数据,超过maxSize时丢弃最早的数据,返回丢弃的条数
+func QueuePush(name string, value []byte, maxSize int) (int, error) {
+	if db == nil {
+		return 0, ErrDBNotReady
+	}
+	dropped := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(QueueBucket))
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(queueKey(seq), value); err != nil {
+			return err
+		}
+		count := queueCount(root, name) + 1
+		for count > uint64(maxSize) {
+			k, _ := b.Cursor().First()
+			if k == nil {
+				break
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			count--
+			dropped++
+		}
+		return setQueueCount(root, name, count)
+	})
+	return dropped, err
+}
+
+// QueueFront 获取队列中最早的一条数据,队列为空时ok为false
+func QueueFront(name string) (seq uint64, value []byte, ok bool, err error) {
+	if db == nil {
+		return 0, nil, false, ErrDBNotReady
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(QueueBucket))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		k, v := b.Cursor().First()
+		if k == nil {
+			return nil
+		}
+		seq = binary.BigEndian.Uint64(k)
+		value = append([]byte(nil), v...)
+		ok = true
+		return nil
+	})
+	return seq, value, ok, err
+}
+
+// QueueRemove 删除队列中的一条数据
+func QueueRemove(name string, seq uint64) error {
+	if db == nil {
+		return ErrDBNotReady
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(QueueBucket))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		key := queueKey(seq)
+		if b.Get(key) == nil {
+			return nil
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		count := queueCount(root, name)
+		if count > 0 {
+			count--
+		}
+		return setQueueCount(root, name, count)
+	})
+}
+
+func queueCount(root *bolt.Bucket, name string) uint64 {
+	v := root.Get([]byte(name + queueCountSuffix))
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func setQueueCount(root *bolt.Bucket, name string, count uint64) error {
+	return root.Put([]byte(name+queueCountSuffix), queueKey(count))
+}
+
+// 大端序保证按序号遍历
+func queueKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
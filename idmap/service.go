@@ -40,13 +40,20 @@ var db *bolt.DB
 var ErrKeyNotFound = errors.New("key not found")
 
 func InitializeDB() {
+	if err := OpenDB(DBName); err != nil {
+		log.Fatalf("Error opening DB: %v", err)
+	}
+}
+
+// OpenDB 打开指定路径的数据库并创建映射所需的bucket
+func OpenDB(path string) error {
 	var err error
-	db, err = bolt.Open(DBName, 0600, nil)
+	db, err = bolt.Open(path, 0600, nil)
 	if err != nil {
-		log.Fatalf("Error opening DB: %v", err)
+		return err
 	}
 
-	db.Update(func(tx *bolt.Tx) error {
+	return db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(BucketName))
 		return err
 	})
@@ -520,6 +527,9 @@ func RetrieveRowByIDv2(rowid string) (string, error) {
 
 // 根据a 以b为类别 储存c
 func WriteConfig(sectionName, keyName, value string) error {
+	if db == nil {
+		return ErrDBNotReady
+	}
 	return db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(ConfigBucket))
 		if err != nil {
@@ -580,6 +590,9 @@ func WriteConfigv2(sectionName, keyName, value string) error {
 
 // 根据a和b取出c
 func ReadConfig(sectionName, keyName string) (string, error) {
+	if db == nil {
+		return "", ErrDBNotReady
+	}
 	var result string
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(ConfigBucket))
@@ -652,6 +665,37 @@ func ReadConfigv2(sectionName, keyName string) (string, error) {
 	return ReadConfig(sectionName, keyName)
 }
 
+// 根据a和b删除c 不存在时不报错
+func DeleteConfig(sectionName, keyName string) error {
+	if db == nil {
+		return ErrDBNotReady
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ConfigBucket))
+		if b == nil {
+			return nil
+		}
+
+		key := joinSectionAndKey(sectionName, keyName)
+		if err := b.Delete(key); err != nil {
+			mylog.Printf("Error deleting data from bucket with key %s: %v", key, err)
+			return fmt.Errorf("failed to delete data from bucket with key %s: %w", key, err)
+		}
+		return nil
+	})
+}
+
+// DeleteConfigv2 根据a和b删除c
+func DeleteConfigv2(sectionName, keyName string) error {
+	if config.GetLotusValue() {
+		// lotus服务端没有删除接口,写入空值代替
+		return WriteConfigv2(sectionName, keyName, "")
+	}
+
+	// 如果lotus为假,则在本地删除
+	return DeleteConfig(sectionName, keyName)
+}
+
 // 灵感,ini配置文件
 func joinSectionAndKey(sectionName, keyName string) []byte {
 	return []byte(sectionName + ":" + keyName)
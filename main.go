@@ -19,11 +19,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/hoshinonyaruko/gensokyo-discord/Processor"
+	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/config"
-	"github.com/hoshinonyaruko/gensokyo-discord/echo"
 	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/httpapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/metrics"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 	"github.com/hoshinonyaruko/gensokyo-discord/server"
 	"github.com/hoshinonyaruko/gensokyo-discord/shorturl"
@@ -90,6 +91,14 @@ func main() {
 	webuiURL := config.ComposeWebUIURL(conf.Settings.Lotus)     // 调用函数获取URL
 	webuiURLv2 := config.ComposeWebUIURLv2(conf.Settings.Lotus) // 调用函数获取URL
 
+	//创建idmap服务器 数据库
+	//需在连接discord之前打开 事件处理、斜杠命令同步和反向ws补发都会读写数据库
+	idmap.InitializeDB()
+	//创建webui数据库
+	webui.InitializeDB()
+	defer idmap.CloseDB()
+	defer webui.CloseDB()
+
 	var wsClients []*wsclient.WebSocketClient
 	var nologin bool
 	var dg *discordgo.Session
@@ -130,6 +139,8 @@ func main() {
 		}
 		// 订阅 Intents
 		registerHandlersFromConfig(dg, conf.Settings.TextIntent)
+		// 网关连接状态 供get_status和心跳使用
+		dg.AddHandler(gatewayStatusHandler)
 		configURL := config.GetDevelop_Acdir()
 		// 开始监听
 		err = dg.Open()
@@ -151,52 +162,35 @@ func main() {
 			handlers.AppID = fmt.Sprintf("%d", conf.Settings.AppID)
 			mylog.Printf("本机器人心跳时的id将会是(取决于config设置的appid):%v\n", handlers.AppID)
 
+			// 注册config中的斜杠命令,与应用设置的命令合并 未配置时保留已注册的命令
+			if len(config.GetCommands()) > 0 {
+				if _, err := handlers.SyncApplicationCommands(dg); err != nil {
+					mylog.Printf("注册斜杠命令失败: %v\n", err)
+				}
+			}
+
 			// 启动多个WebSocket客户端的逻辑
-			if !allEmpty(conf.Settings.WsAddress) {
-				wsClientChan := make(chan *wsclient.WebSocketClient, len(conf.Settings.WsAddress))
-				errorChan := make(chan error, len(conf.Settings.WsAddress))
-				// 定义计数器跟踪尝试建立的连接数
-				attemptedConnections := 0
-				for _, wsAddr := range conf.Settings.WsAddress {
-					if wsAddr == "" {
-						continue // Skip empty addresses
-					}
-					attemptedConnections++ // 增加尝试连接的计数
-					go func(address string) {
+			// 包括ws_address和拆分的API、Event连接
+			if connections := config.GetReverseWsConnections(); len(connections) > 0 {
+				// 启动时未连上的client会在后台重连,事件先进入队列
+				wsClientChan := make(chan *wsclient.WebSocketClient, len(connections))
+				for _, connection := range connections {
+					go func(address string, role string) {
 						retry := config.GetLaunchReconectTimes()
-						wsClient, err := wsclient.NewWebSocketClient(address, conf.Settings.AppID, dg, retry)
-						if err != nil {
-							mylog.Printf("Error creating WebSocketClient for address(连接到反向ws失败) %s: %v\n", address, err)
-							errorChan <- err
-							return
-						}
-						wsClientChan <- wsClient
-					}(wsAddr)
+						wsClientChan <- wsclient.NewWebSocketClient(address, role, conf.Settings.AppID, dg, retry)
+					}(connection.Address, connection.Role)
 				}
-				// 获取连接成功后的wsClient
-				for i := 0; i < attemptedConnections; i++ {
-					select {
-					case wsClient := <-wsClientChan:
-						wsClients = append(wsClients, wsClient)
-					case err := <-errorChan:
-						mylog.Printf("Error encountered while initializing WebSocketClient: %v\n", err)
-					}
+				for range connections {
+					wsClients = append(wsClients, <-wsClientChan)
 				}
-
-				// 确保所有尝试建立的连接都有对应的wsClient
-				if len(wsClients) != attemptedConnections {
-					mylog.Println("Error: Not all wsClients are initialized!(反向ws未设置或连接失败)")
-					// 处理初始化失败的情况
-					p = Processor.NewProcessorV2(&conf.Settings)
-					//只启动正向
-				} else {
-					mylog.Println("All wsClients are successfully initialized.")
-					// 所有客户端都成功初始化
-					p = Processor.NewProcessor(&conf.Settings, wsClients)
+				mylog.Println("All wsClients are initialized.")
+				p = Processor.NewProcessor(&conf.Settings, wsClients, dg)
+			} else {
+				if conf.Settings.EnableWsServer {
+					mylog.Println("只启动正向ws")
 				}
-			} else if conf.Settings.EnableWsServer {
-				mylog.Println("只启动正向ws")
-				p = Processor.NewProcessorV2(&conf.Settings)
+				// 只使用正向ws或http时同样需要处理事件
+				p = Processor.NewProcessorV2(&conf.Settings, dg)
 			}
 		} else {
 			// 设置颜色为红色
@@ -206,13 +200,6 @@ func main() {
 		}
 	}
 
-	//创建idmap服务器 数据库
-	idmap.InitializeDB()
-	//创建webui数据库
-	webui.InitializeDB()
-	defer idmap.CloseDB()
-	defer webui.CloseDB()
-
 	//logger
 	//logLevel := mylog.GetLogLevelFromConfig(config.GetLogLevel())
 	//loggerAdapter := mylog.NewlogAdapter(logLevel, config.GetSaveLogs())
@@ -258,6 +245,10 @@ func main() {
 	http_api_address := config.GetHttpAddress()
 	if http_api_address != "" {
 		mylog.Println("正向http api启动成功,监听" + http_api_address + "若有需要,请对外放通端口...")
+		if config.GetHttpAccessToken() == "" {
+			mylog.Println("警告:未设置http_access_token,正向http api监听地址非本机时任何人都可以调用")
+		}
+		hr.Use(httpapi.AuthMiddleware(), httpapi.RateLimitMiddleware(httpapi.NewRateLimiter()))
 		HttpApiGroup := hr.Group("/")
 		{
 			HttpApiGroup.GET("/*filepath", httpapi.CombinedMiddleware(dg))
@@ -278,6 +269,15 @@ func main() {
 				r.GET("/"+wspath, server.WsHandlerWithDependencies(dg, p))
 				mylog.Println("正向ws启动成功,监听0.0.0.0:" + serverPort + "/" + wspath + "请注意设置ws_server_token(可空),并对外放通端口...")
 			}
+			// onebotv11的API和Event连接分别监听ws_server_path/api和ws_server_path/event
+			basePath := "/" + wspath
+			if wspath == "nil" || wspath == "" {
+				basePath = ""
+			}
+			apiPath, eventPath := basePath+"/api", basePath+"/event"
+			r.GET(apiPath, server.WsRoleHandlerWithDependencies(dg, p, callapi.RoleAPI))
+			r.GET(eventPath, server.WsRoleHandlerWithDependencies(dg, p, callapi.RoleEvent))
+			mylog.Println("正向ws的API连接监听" + apiPath + ",Event连接监听" + eventPath)
 		}
 	}
 	r.POST("/url", shorturl.CreateShortURLHandler)
@@ -364,6 +364,22 @@ func main() {
 	dg.Close()
 }
 
+// 记录网关的连接、断开和恢复
+func gatewayStatusHandler(s *discordgo.Session, i interface{}) {
+	switch i.(type) {
+	case *discordgo.Connect:
+		metrics.GatewayConnected()
+	case *discordgo.Ready:
+		metrics.GatewayReady()
+	case *discordgo.Disconnect:
+		metrics.GatewayDisconnected()
+		mylog.Printf("与Discord网关的连接已断开,等待重连")
+	case *discordgo.Resumed:
+		metrics.GatewayResumed()
+		mylog.Printf("已恢复与Discord网关的会话")
+	}
+}
+
 func guildsHandler(s *discordgo.Session, i interface{}) {
 	event, ok := i.(*discordgo.GuildCreate)
 	if !ok {
@@ -376,25 +392,36 @@ func guildsHandler(s *discordgo.Session, i interface{}) {
 }
 
 func guildMembersHandler(s *discordgo.Session, i interface{}) {
-	event, ok := i.(*discordgo.GuildMemberAdd)
-	if !ok {
-		//mylog.Println("Event type mismatch: expected *discordgo.GuildMemberAdd")
-		return
+	switch event := i.(type) {
+	case *discordgo.GuildMemberAdd:
+		// 处理 GuildMemberAdd 事件
+		if event.Member != nil && event.User != nil {
+			mylog.Printf("New member added: %s", event.User.Username)
+		}
+		p.ProcessGuildMemberAdd(event, s)
+	case *discordgo.GuildMemberRemove:
+		// 处理 GuildMemberRemove 事件
+		if event.Member != nil && event.User != nil {
+			mylog.Printf("Member removed: %s", event.User.Username)
+		}
+		p.ProcessGuildMemberRemove(event, s)
+	case *discordgo.GuildMemberUpdate:
+		// 处理成员审核状态变化
+		p.ProcessGuildMemberUpdate(event, s)
 	}
-
-	// 处理 GuildMemberAdd 事件
-	mylog.Printf("New member added: %s", event.Member.User.Username)
 }
 
 func guildBansHandler(s *discordgo.Session, i interface{}) {
-	event, ok := i.(*discordgo.GuildBanAdd)
-	if !ok {
-		//mylog.Println("Event type mismatch: expected *discordgo.GuildBanAdd")
-		return
+	switch event := i.(type) {
+	case *discordgo.GuildBanAdd:
+		// 处理 GuildBanAdd 事件
+		mylog.Printf("Member banned: %s", event.User.Username)
+		p.ProcessGuildBanAdd(event, s)
+	case *discordgo.GuildBanRemove:
+		// 处理 GuildBanRemove 事件
+		mylog.Printf("Member unbanned: %s", event.User.Username)
+		p.ProcessGuildBanRemove(event, s)
 	}
-
-	// 处理 GuildBanAdd 事件
-	mylog.Printf("Member banned: %s", event.User.Username)
 }
 
 func guildEmojisHandler(s *discordgo.Session, i interface{}) {
@@ -480,9 +507,23 @@ func registerHandlersFromConfig(dg *discordgo.Session, intents []string) {
 }
 
 func guildMessagesHandler(s *discordgo.Session, i interface{}) {
-	event, ok := i.(*discordgo.MessageCreate)
-	if !ok {
-		// mylog.Println("Event type mismatch: expected *discordgo.MessageCreate")
+	var event *discordgo.MessageCreate
+	switch e := i.(type) {
+	case *discordgo.MessageCreate:
+		event = e
+	case *discordgo.MessageUpdate:
+		if e.Author != nil && e.Author.ID == globalBotId {
+			return
+		}
+		p.ProcessMessageUpdate(e, s)
+		return
+	case *discordgo.MessageDelete:
+		p.ProcessMessageDelete(e, s)
+		return
+	case *discordgo.MessageDeleteBulk:
+		p.ProcessMessageDeleteBulk(e, s)
+		return
+	default:
 		return
 	}
 	if event.Author.ID == globalBotId {
@@ -501,14 +542,18 @@ func guildMessagesHandler(s *discordgo.Session, i interface{}) {
 }
 
 func guildMessageReactionsHandler(s *discordgo.Session, i interface{}) {
-	// event, ok := i.(*discordgo.MessageReactionAdd)
-	// if !ok {
-	// 	mylog.Println("Event type mismatch: expected *discordgo.MessageReactionAdd")
-	// 	return
-	// }
-
-	// 处理 MessageReactionAdd 事件
-	// 例如: mylog.Printf("New reaction added to message: %s in channel: %s", event.MessageID, event.ChannelID)
+	switch event := i.(type) {
+	case *discordgo.MessageReactionAdd:
+		if event.GuildID == "" || event.UserID == globalBotId {
+			return
+		}
+		p.ProcessMessageReaction(event.MessageReaction, true, s)
+	case *discordgo.MessageReactionRemove:
+		if event.GuildID == "" || event.UserID == globalBotId {
+			return
+		}
+		p.ProcessMessageReaction(event.MessageReaction, false, s)
+	}
 }
 
 func guildMessageTypingHandler(s *discordgo.Session, i interface{}) {
@@ -525,60 +570,11 @@ func guildMessageTypingHandler(s *discordgo.Session, i interface{}) {
 func interactionCreateHandler(s *discordgo.Session, i interface{}) {
 	event, ok := i.(*discordgo.InteractionCreate)
 	if !ok {
-		//mylog.Println("Event type mismatch: expected *discordgo.TypingStart")
+		//mylog.Println("Event type mismatch: expected *discordgo.InteractionCreate")
 		return
 	}
-
-	groupMsg := Processor.OnebotGroupMessageS{
-		RawMessage:  event.MessageComponentData().CustomID,
-		Message:     event.MessageComponentData().CustomID,
-		MessageID:   event.ID,
-		GroupID:     event.ChannelID,
-		MessageType: "group",
-		PostType:    "message",
-		SelfID:      int64(p.Settings.AppID),
-		UserID:      event.Member.User.ID,
-		Sender: Processor.Sender{
-			Nickname: event.Member.User.Username,
-			UserID:   0,
-			Card:     event.Member.User.Username,
-			Sex:      "0",
-			Age:      0,
-			Area:     "",
-			Level:    "0",
-		},
-		SubType:         "normal",
-		Time:            time.Now().Unix(),
-		Avatar:          event.Member.User.Avatar,
-		RealMessageType: "guild",
-		RealGroupID:     event.ChannelID,
-		RealUserID:      event.Member.User.ID,
-	}
-
-	//储存当前群或频道号的类型
-	idmap.WriteConfigv2(event.ChannelID, "type", "guild")
-	//懒message_id池
-	echo.AddLazyMessageId(event.ChannelID, event.ID, time.Now())
-
-	//调试
-	Processor.PrintStructWithFieldNames(groupMsg)
-
-	// Convert OnebotGroupMessage to map and send
-	groupMsgMap := structToMap(groupMsg)
-
-	//上报信息到onebotv11应用端(正反ws)
-	p.BroadcastMessageToAll(groupMsgMap)
-
-	// 处理 TypingStart 事件
-	mylog.Printf("interactionCreateHandler in channel: %s", event.ChannelID)
-
-	// 向 Discord 发送确认响应，不发送任何消息
-	err := s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-	})
-	if err != nil {
-		mylog.Printf("响应交互失败: %v", err)
-		return
+	if err := p.ProcessInteraction(event, s); err != nil {
+		mylog.Printf("处理交互失败: %v", err)
 	}
 }
 
@@ -606,19 +602,22 @@ func directMessagesHandler(s *discordgo.Session, i interface{}) {
 	// 例如: mylog.Printf("New direct message from user: %s, content: %s", event.Author.ID, event.Content)
 }
 func directMessageReactionsHandler(s *discordgo.Session, i interface{}) {
-	event, ok := i.(*discordgo.MessageReactionAdd)
-	if !ok {
-		//mylog.Println("Event type mismatch: expected *discordgo.MessageReactionAdd")
-		return
-	}
-
 	// 只处理私人消息的反应
-	// 这需要检查消息是否来自私人频道
-	// ...
-
-	// 处理私人消息的反应
-	mylog.Printf("New reaction added to direct message: %s", event.MessageID)
+	switch event := i.(type) {
+	case *discordgo.MessageReactionAdd:
+		if event.GuildID != "" || event.UserID == globalBotId {
+			return
+		}
+		mylog.Printf("New reaction added to direct message: %s", event.MessageID)
+		p.ProcessMessageReaction(event.MessageReaction, true, s)
+	case *discordgo.MessageReactionRemove:
+		if event.GuildID != "" || event.UserID == globalBotId {
+			return
+		}
+		p.ProcessMessageReaction(event.MessageReaction, false, s)
+	}
 }
+
 func directMessageTypingHandler(s *discordgo.Session, i interface{}) {
 	event, ok := i.(*discordgo.TypingStart)
 	if !ok {
@@ -698,13 +697,3 @@ func mapIntentToHandler(intentName string) func(*discordgo.Session, interface{})
 		return nil
 	}
 }
-
-// allEmpty checks if all the strings in the slice are empty.
-func allEmpty(addresses []string) bool {
-	for _, addr := range addresses {
-		if addr != "" {
-			return false
-		}
-	}
-	return true
-}
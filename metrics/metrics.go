@@ -0,0 +1,113 @@
+// 运行时统计 供get_status和心跳使用
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 某一时刻的统计快照
+type Stats struct {
+	MessageReceived uint64 // 从discord收到的消息数
+	MessageSent     uint64 // 成功发送到discord的消息数
+	EventPosted     uint64 // 上报给应用端的事件数
+	APICalls        uint64 // 应用端调用的action数
+	SendFailed      uint64 // 发送到discord失败的次数
+	Disconnects     uint64 // 网关断开次数
+	Resumes         uint64 // 网关恢复(resume)次数
+	Lost            uint64 // 断线后未能恢复会话,重新identify的次数
+	LastMessageTime int64  // 最后一次收到或发送消息的时间戳
+	Online          bool   // 网关当前是否在线
+	StartTime       int64  // 框架启动时间戳
+}
+
+var (
+	messageReceived uint64
+	messageSent     uint64
+	eventPosted     uint64
+	apiCalls        uint64
+	sendFailed      uint64
+	disconnects     uint64
+	resumes         uint64
+	lost            uint64
+	awaitingSession int32 // 断线后尚未恢复或重建会话
+	lastMessageTime int64
+	online          int32
+	startTime       = time.Now().Unix()
+)
+
+// AddMessageReceived 收到一条discord消息
+func AddMessageReceived() {
+	atomic.AddUint64(&messageReceived, 1)
+	atomic.StoreInt64(&lastMessageTime, time.Now().Unix())
+}
+
+// AddMessageSent 成功发送一条消息
+func AddMessageSent() {
+	atomic.AddUint64(&messageSent, 1)
+	atomic.StoreInt64(&lastMessageTime, time.Now().Unix())
+}
+
+// AddSendFailed 发送消息失败
+func AddSendFailed() {
+	atomic.AddUint64(&sendFailed, 1)
+}
+
+// AddEventPosted 向应用端上报了一个事件
+func AddEventPosted() {
+	atomic.AddUint64(&eventPosted, 1)
+}
+
+// AddAPICall 收到一次action调用
+func AddAPICall() {
+	atomic.AddUint64(&apiCalls, 1)
+}
+
+// GatewayConnected 网关连接成功
+func GatewayConnected() {
+	atomic.StoreInt32(&online, 1)
+}
+
+// GatewayReady 网关完成identify 发生在断线之后时说明会话已丢失
+func GatewayReady() {
+	atomic.StoreInt32(&online, 1)
+	if atomic.CompareAndSwapInt32(&awaitingSession, 1, 0) {
+		atomic.AddUint64(&lost, 1)
+	}
+}
+
+// GatewayDisconnected 网关断开
+func GatewayDisconnected() {
+	atomic.StoreInt32(&online, 0)
+	atomic.StoreInt32(&awaitingSession, 1)
+	atomic.AddUint64(&disconnects, 1)
+}
+
+// GatewayResumed 网关断线后恢复了会话
+func GatewayResumed() {
+	atomic.StoreInt32(&online, 1)
+	atomic.StoreInt32(&awaitingSession, 0)
+	atomic.AddUint64(&resumes, 1)
+}
+
+// Online 网关当前是否在线
+func Online() bool {
+	return atomic.LoadInt32(&online) == 1
+}
+
+// Snapshot 获取当前统计
+func Snapshot() Stats {
+	return Stats{
+		MessageReceived: atomic.LoadUint64(&messageReceived),
+		MessageSent:     atomic.LoadUint64(&messageSent),
+		EventPosted:     atomic.LoadUint64(&eventPosted),
+		APICalls:        atomic.LoadUint64(&apiCalls),
+		SendFailed:      atomic.LoadUint64(&sendFailed),
+		Disconnects:     atomic.LoadUint64(&disconnects),
+		Resumes:         atomic.LoadUint64(&resumes),
+		Lost:            atomic.LoadUint64(&lost),
+		LastMessageTime: atomic.LoadInt64(&lastMessageTime),
+		Online:          Online(),
+		StartTime:       startTime,
+	}
+}
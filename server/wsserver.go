@@ -2,8 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -12,12 +15,43 @@ import (
 	"github.com/hoshinonyaruko/gensokyo-discord/Processor"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 	"github.com/hoshinonyaruko/gensokyo-discord/wsclient"
 )
 
+// 每个客户端待发送消息队列的长度
+const clientSendQueueSize = 1024
+
+// 队列持续满超过该时间的客户端将被断开
+const clientQueueFullTimeout = 5 * time.Second
+
+// 单条消息的写超时
+const clientWriteTimeout = 10 * time.Second
+
+var errClientClosed = errors.New("websocket client closed")
+
+// 正向ws客户端 所有写操作都由writeLoop完成,gorilla/websocket不允许并发写
 type WebSocketServerClient struct {
-	Conn *websocket.Conn
+	Conn       *websocket.Conn
+	ClientRole string // Universal API Event
+
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	fullMu    sync.Mutex
+	fullSince time.Time // 队列开始持续满的时间,未满时为零值
+}
+
+func newWebSocketServerClient(conn *websocket.Conn, role string) *WebSocketServerClient {
+	client := &WebSocketServerClient{
+		Conn:       conn,
+		ClientRole: role,
+		send:       make(chan []byte, clientSendQueueSize),
+		done:       make(chan struct{}),
+	}
+	go client.writeLoop()
+	return client
 }
 
 var upgrader = websocket.Upgrader{
@@ -30,14 +64,27 @@ var upgrader = websocket.Upgrader{
 var _ callapi.WebSocketServerClienter = &WebSocketServerClient{}
 
 // 使用闭包结构 因为gin需要c *gin.Context固定签名
+// 通用路径 连接的角色由X-Client-Role决定,未提供时为Universal
 func WsHandlerWithDependencies(s *discordgo.Session, p *Processor.Processors) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		wsHandler(s, p, c)
+		header := c.GetHeader("X-Client-Role")
+		role := callapi.NormalizeClientRole(header)
+		if header != "" && !strings.EqualFold(strings.TrimSpace(header), role) {
+			mylog.Printf("Warning: 无法识别的X-Client-Role %q,该连接将作为Universal连接", header)
+		}
+		wsHandler(s, p, c, role)
+	}
+}
+
+// onebotv11的/api和/event路径 连接的角色由路径决定
+func WsRoleHandlerWithDependencies(s *discordgo.Session, p *Processor.Processors, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wsHandler(s, p, c, role)
 	}
 }
 
 // 处理正向ws客户端的连接
-func wsHandler(s *discordgo.Session, p *Processor.Processors, c *gin.Context) {
+func wsHandler(s *discordgo.Session, p *Processor.Processors, c *gin.Context, role string) {
 	// 先从请求头中尝试获取token
 	tokenFromHeader := c.Request.Header.Get("Authorization")
 	token := ""
@@ -79,43 +126,39 @@ func wsHandler(s *discordgo.Session, p *Processor.Processors, c *gin.Context) {
 	}
 
 	clientIP := c.ClientIP()
-	mylog.Printf("WebSocket client connected. IP: %s", clientIP)
+	mylog.Printf("WebSocket client connected. IP: %s, Role: %s", clientIP, role)
 
 	// 创建WebSocketServerClient实例
-	client := &WebSocketServerClient{
-		Conn: conn,
-	}
+	client := newWebSocketServerClient(conn, role)
 	// 将此客户端添加到Processor的WsServerClients列表中
-	p.WsServerClients = append(p.WsServerClients, client)
+	p.AddWsServerClient(client)
 
 	// 获取botID
 	botID := config.GetAppID()
 
-	// 发送连接成功的消息
-	message := map[string]interface{}{
-		"meta_event_type": "lifecycle",
-		"post_type":       "meta_event",
-		"self_id":         botID,
-		"sub_type":        "connect",
-		"time":            int(time.Now().Unix()),
-	}
-	err = client.SendMessage(message)
-	if err != nil {
-		mylog.Printf("Error sending connection success message: %v\n", err)
+	// 发送连接成功的消息 API连接不接收事件
+	if role != callapi.RoleAPI {
+		message := map[string]interface{}{
+			"meta_event_type": "lifecycle",
+			"post_type":       "meta_event",
+			"self_id":         botID,
+			"sub_type":        "connect",
+			"time":            int(time.Now().Unix()),
+		}
+		err = client.SendMessage(message)
+		if err != nil {
+			mylog.Printf("Error sending connection success message: %v\n", err)
+		}
+		go client.heartbeatLoop(s, botID)
 	}
 
 	// 在defer语句之前运行
 	defer func() {
 		// 移除客户端从WsServerClients
-		for i, wsClient := range p.WsServerClients {
-			if wsClient == client {
-				p.WsServerClients = append(p.WsServerClients[:i], p.WsServerClients[i+1:]...)
-				break
-			}
-		}
+		p.RemoveWsServerClient(client)
 	}()
 	//退出时候的清理
-	defer conn.Close()
+	defer client.closeWithReason("connection closed")
 
 	for {
 		messageType, p, err := conn.ReadMessage()
@@ -139,20 +182,97 @@ func processWSMessage(client *WebSocketServerClient, msg []byte, s *discordgo.Se
 	}
 
 	mylog.Println("Received from WebSocket onebotv11 client:", wsclient.TruncateMessage(message, 500))
+	// Event连接只推送事件
+	if client.ClientRole == callapi.RoleEvent {
+		callapi.SendFailedResponse(client, callapi.RetCodeForbidden, "EVENT_ONLY_CONNECTION", "当前连接为Event连接,不接受action调用,请使用Universal或API连接", message.Echo)
+		return
+	}
 	// 调用callapi
 	callapi.CallAPIFromDict(client, s, message)
 }
 
-// 发信息给client
+// 发信息给client 消息进入队列后立即返回,队列满时丢弃该消息
 func (c *WebSocketServerClient) SendMessage(message map[string]interface{}) error {
 	msgBytes, err := json.Marshal(message)
 	if err != nil {
 		mylog.Println("Error marshalling message:", err)
 		return err
 	}
-	return c.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case c.send <- msgBytes:
+		c.fullMu.Lock()
+		c.fullSince = time.Time{}
+		c.fullMu.Unlock()
+		return nil
+	default:
+	}
+
+	// 队列已满 客户端接收过慢
+	c.fullMu.Lock()
+	if c.fullSince.IsZero() {
+		c.fullSince = time.Now()
+	}
+	fullFor := time.Since(c.fullSince)
+	c.fullMu.Unlock()
+	if fullFor >= clientQueueFullTimeout {
+		c.closeWithReason(fmt.Sprintf("outbound queue full for %v", fullFor.Round(time.Second)))
+		return errClientClosed
+	}
+	return fmt.Errorf("outbound queue full, message dropped")
+}
+
+// 唯一的写协程 写失败时断开连接
+func (c *WebSocketServerClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msgBytes := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+				c.closeWithReason(fmt.Sprintf("write failed: %v", err))
+				return
+			}
+		}
+	}
+}
+
+// 按配置的间隔发送心跳 连接关闭后退出
+func (c *WebSocketServerClient) heartbeatLoop(s *discordgo.Session, selfID uint64) {
+	interval := time.Duration(config.GetHeartBeatInterval()) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.SendMessage(handlers.NewHeartbeatEvent(s, selfID, interval))
+		}
+	}
+}
+
+// 关闭连接,读循环随之退出并从列表中移除该客户端
+func (c *WebSocketServerClient) closeWithReason(reason string) {
+	c.closeOnce.Do(func() {
+		mylog.Printf("正向ws客户端断开 IP: %s, Role: %s, 原因: %s", c.Conn.RemoteAddr(), c.ClientRole, reason)
+		close(c.done)
+		c.Conn.Close()
+	})
 }
 
 func (client *WebSocketServerClient) Close() error {
-	return client.Conn.Close()
+	client.closeWithReason("closed by server")
+	return nil
+}
+
+func (client *WebSocketServerClient) Role() string {
+	return client.ClientRole
 }
@@ -6,6 +6,13 @@ settings:
   #反向ws设置
   ws_address: ["ws://<YOUR_WS_ADDRESS>:<YOUR_WS_PORT>"] # WebSocket服务的地址 支持多个["","",""]
   ws_token: ["","",""]              #连接wss地址时所需的token,按顺序一一对应,如果是ws地址,没有密钥,请留空.
+  ws_role: ["","",""]               #反向ws连接的角色,按顺序一一对应,可选Universal(默认)、API(只接受action)、Event(只推送事件)
+  ws_api_address: [""]              #部分框架需要拆分的反向ws,与ws_event_address按顺序成对,分别为API连接和Event连接的地址,不使用请留空
+  ws_event_address: [""]
+  ws_split_token: [""]              #拆分的反向ws每一对连接所需的token,按顺序一一对应
+  ws_queue_size: 1000               #反向ws断线期间每个连接最多暂存的事件数,超出时丢弃最早的事件,重连后按顺序补发
+  ws_queue_ttl: 300                 #暂存事件的有效期,单位秒,过期的事件不再补发
+  ws_queue_persist: false           #将暂存事件写入idmap数据库,应用端重启期间gensokyo重启也不会丢失
   token: "<YOUR_APP_TOKEN>"                          # 你的机器人令牌
   app_id: 12345                             # appid在discord并没有实际意义,作为onebotv11连接和心跳时的"qq"值,可随意填写
 
@@ -51,15 +58,16 @@ settings:
   master_id : ["1","2"]             #群场景尚未开放获取管理员和列表能力,手动从日志中获取需要设置为管理,的user_id并填入(适用插件有权限判断场景)
   record_sampleRate : 24000         #语音文件的采样率 最高48000 默认24000 单位Khz
   record_bitRate : 24000            #语音文件的比特率 默认25000 代表 25 kbps 最高无限 请根据带宽 您发送的实际码率调整
+                                    #discord无法播放的语音(如amr)会使用以上两项通过ffmpeg转码为ogg/opus,需要ffmpeg在PATH中
   card_nick : ""                    #默认为空,连接mirai-overflow时,请设置为非空,这里是机器人对用户称谓,为空为插件获取,mirai不支持
   auto_bind : true                  #测试功能,后期会移除
   AMsgRetryAsPMsg_Count : 1         #当主动信息发送失败时,自动转为后续的被动信息发送,需要开启Lazy message id,该配置项为每次跟随被动信息发送的信息数量,最大5,建议1-3
-  reconnect_times : 100             #反向ws连接失败后的重试次数,希望一直重试,可设置9999
-  heart_beat_interval : 10          #反向ws心跳间隔 单位秒 推荐5-10
-  launch_reconnect_times : 1        #启动时尝试反向ws连接次数,建议先打开应用端再开启gensokyo,因为启动时连接会阻塞webui启动,默认只连接一次,可自行增大
+  reconnect_times : 100             #已不再使用,反向ws断线后会以指数退避(最长60秒)一直重连
+  heart_beat_interval : 10          #正向和反向ws心跳间隔 单位秒 推荐5-10
+  launch_reconnect_times : 1        #启动时尝试反向ws连接次数,建议先打开应用端再开启gensokyo,因为启动时连接会阻塞webui启动,默认只连接一次,可自行增大,仍未连上时转入后台重连
 
   #正向ws设置
-  ws_server_path : "ws"             #默认监听0.0.0.0:port/ws_server_path 若有安全需求,可不放通port到公网,或设置ws_server_token 若想监听/ 可改为"",若想监听到不带/地址请写nil
+  ws_server_path : "ws"             #默认监听0.0.0.0:port/ws_server_path 若有安全需求,可不放通port到公网,或设置ws_server_token 若想监听/ 可改为"",若想监听到不带/地址请写nil,另在ws_server_path/api和ws_server_path/event监听onebotv11的API和Event连接
   enable_ws_server: true            #是否启用正向ws 监听server_dir:port/ws_server_path
   ws_server_token : "12345"         #正向ws的token 不启动正向ws可忽略 可为空
 
@@ -105,6 +113,10 @@ settings:
   sandbox_mode : false              #默认false 如果你只希望沙箱频道使用,请改为true
   dev_message_id : false            #在沙盒和测试环境使用无限制msg_id 仅沙盒有效,正式环境请关闭,内测结束后,tx侧未来会移除
   send_error : true                 #将报错用文本发出,避免机器人被审核报无响应
+  always_ok_response : false        #兼容旧行为,action调用失败时仍返回status=ok retcode=0,依赖旧行为的机器人可开启
+  request_approve_role : ""         #开启成员审核(membership screening)的服务器,set_group_add_request同意时授予的身份组id,授予身份组即可跳过审核.为空时无法同意加群请求
+  recall_audit_log : false          #撤回事件通过审计日志查找删除消息的管理员(operator_id),每次删除都会请求一次审计日志,需要查看审计日志权限.关闭时operator_id为消息作者
+  member_audit_log : false          #成员退出和封禁事件通过审计日志区分踢出(kick)并查找操作的管理员(operator_id),每次都会请求一次审计日志,需要查看审计日志权限.关闭时退出均为leave,operator_id为0
   url_pic_transfer : false          #将url转为base64,走代理上传到dc,在国内环境,比url更快发图
   idmap_pro : false                 #需开启hash_id配合,高级id转换增强,可以多个真实值bind到同一个虚拟值,对于每个用户,每个群\私聊\判断私聊\频道,都会产生新的虚拟值,但可以多次bind,bind到同一个数字.数据库负担会变大.
   send_delay : 300                  #单位 毫秒 默认300ms 可以视情况减少到100或者50
@@ -132,6 +144,8 @@ settings:
 
   #正向http
   http_address: ""                  #http监听地址 与websocket独立 示例:0.0.0.0:5700 为空代表不开启
+  http_access_token: ""             #正向http的access_token 通过Authorization: Bearer头或?access_token=传入 监听地址非本机时请务必设置
+  http_rate_limit: 600              #每个ip每分钟最多调用正向http的次数 0为不限制
   http_version : 11                 #暂时只支持11
   http_timeout: 5                   #反向 HTTP 超时时间, 单位秒，<5 时将被忽略
 
@@ -140,6 +154,10 @@ settings:
   post_secret: [""]                 #密钥
   post_max_retries: [3]             #最大重试,0 时禁用
   post_retries_interval: [1500]     #重试时间,单位毫秒,0 时立即
+
+  #斜杠命令
+  commands: []                      #启动时注册到discord的斜杠命令,guild_id为空时为全局命令.与set_application_commands设置的命令合并注册,同名时以后者为准.为空时不同步,保留已注册的命令 示例:[{name: roll, description: 掷骰子, options: [{type: integer, name: sides, description: 面数, required: true}]}]
+  autocomplete_timeout: 2500        #单位毫秒 等待应用端通过send_autocomplete_result返回自动补全候选的时间,超时返回空候选.discord要求3秒内响应,最大2800
 `
 const Logo = `
 '                                                                                                      
@@ -0,0 +1,131 @@
+package wsclient
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/idmap"
+	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
+)
+
+// 断线期间暂存的事件
+type queuedEvent struct {
+	Seq      uint64                 `json:"-"`
+	Time     int64                  `json:"time"` // 入队时间 毫秒
+	Message  map[string]interface{} `json:"message"`
+	inMemory bool
+}
+
+// 反向ws断线期间的事件队列 有长度和有效期限制
+// 开启ws_queue_persist时写入idmap数据库,数据库尚未初始化时暂存在内存
+type eventQueue struct {
+	name    string
+	memory  []queuedEvent
+	nextSeq uint64
+}
+
+func newEventQueue(name string) *eventQueue {
+	return &eventQueue{name: name}
+}
+
+// 只暂存事件 心跳和生命周期事件在重连后会重新产生,action的响应补发也没有意义
+func shouldQueue(message map[string]interface{}) bool {
+	postType, _ := message["post_type"].(string)
+	return postType != "" && postType != "meta_event"
+}
+
+func (q *eventQueue) push(message map[string]interface{}) {
+	event := queuedEvent{Time: time.Now().UnixMilli(), Message: message}
+	maxSize := config.GetWsQueueSize()
+
+	if config.GetWsQueuePersist() {
+		data, err := json.Marshal(event)
+		if err == nil {
+			var dropped int
+			dropped, err = idmap.QueuePush(q.name, data, maxSize)
+			if err == nil {
+				if dropped > 0 {
+					mylog.Printf("反向ws[%s]暂存队列已满,丢弃了%d条最早的事件", q.name, dropped)
+				}
+				return
+			}
+		}
+		mylog.Printf("反向ws[%s]事件写入数据库失败,暂存在内存: %v", q.name, err)
+	}
+
+	q.nextSeq++
+	event.Seq = q.nextSeq
+	event.inMemory = true
+	q.memory = append(q.memory, event)
+	if len(q.memory) > maxSize {
+		dropped := len(q.memory) - maxSize
+		q.memory = q.memory[dropped:]
+		mylog.Printf("反向ws[%s]暂存队列已满,丢弃了%d条最早的事件", q.name, dropped)
+	}
+}
+
+// 获取最早的未过期事件 过期事件直接丢弃
+// 内存中的事件是数据库写入失败时暂存的,可能与数据库中的事件交错,按入队时间取较早的一条
+func (q *eventQueue) front() (queuedEvent, bool) {
+	memoryEvent, memoryOK := q.memoryFront()
+	dbEvent, dbOK := q.dbFront()
+	switch {
+	case memoryOK && dbOK:
+		if dbEvent.Time < memoryEvent.Time {
+			return dbEvent, true
+		}
+		return memoryEvent, true
+	case memoryOK:
+		return memoryEvent, true
+	default:
+		return dbEvent, dbOK
+	}
+}
+
+func (q *eventQueue) memoryFront() (queuedEvent, bool) {
+	for len(q.memory) > 0 {
+		event := q.memory[0]
+		if !q.expired(event) {
+			return event, true
+		}
+		q.memory = q.memory[1:]
+	}
+	return queuedEvent{}, false
+}
+
+func (q *eventQueue) dbFront() (queuedEvent, bool) {
+	for {
+		seq, data, ok, err := idmap.QueueFront(q.name)
+		if err != nil || !ok {
+			return queuedEvent{}, false
+		}
+		var event queuedEvent
+		if err := json.Unmarshal(data, &event); err == nil && !q.expired(event) {
+			event.Seq = seq
+			return event, true
+		}
+		if err := idmap.QueueRemove(q.name, seq); err != nil {
+			mylog.Printf("反向ws[%s]删除过期事件失败: %v", q.name, err)
+			return queuedEvent{}, false
+		}
+	}
+}
+
+func (q *eventQueue) expired(event queuedEvent) bool {
+	ttl := time.Duration(config.GetWsQueueTTL()) * time.Second
+	return time.Now().UnixMilli()-event.Time > ttl.Milliseconds()
+}
+
+// 移除front返回的事件
+func (q *eventQueue) remove(event queuedEvent) {
+	if event.inMemory {
+		if len(q.memory) > 0 && q.memory[0].Seq == event.Seq {
+			q.memory = q.memory[1:]
+		}
+		return
+	}
+	if err := idmap.QueueRemove(q.name, event.Seq); err != nil {
+		mylog.Printf("反向ws[%s]删除已补发事件失败: %v", q.name, err)
+	}
+}
@@ -3,7 +3,9 @@ package wsclient
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"sync"
@@ -13,55 +15,95 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/hoshinonyaruko/gensokyo-discord/callapi"
 	"github.com/hoshinonyaruko/gensokyo-discord/config"
+	"github.com/hoshinonyaruko/gensokyo-discord/handlers"
 	"github.com/hoshinonyaruko/gensokyo-discord/mylog"
 )
 
+// 断线重连的等待时间 每次失败翻倍,直到上限
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 60 * time.Second
+)
+
+// 单条消息的写超时
+const writeTimeout = 10 * time.Second
+
+var errNotConnected = errors.New("websocket is not connected")
+
 type WebSocketClient struct {
 	conn           *websocket.Conn
 	dg             *discordgo.Session
 	botID          uint64
 	urlStr         string
+	role           string // Universal API Event
 	cancel         context.CancelFunc
 	mutex          sync.Mutex // 用于同步写入和重连操作的互斥锁
+	connected      bool       // 已连接且暂存的事件已补发完毕
+	replaying      bool       // 正在补发暂存的事件 此时action的响应直接写入连接
 	isReconnecting bool
-	sendFailures   chan map[string]interface{}
+	closed         bool
+	queue          *eventQueue // 断线期间暂存的事件
 }
 
-// 发送json信息给onebot应用端
+// 发送json信息给onebot应用端 断线或补发期间事件进入队列,保证顺序
 func (c *WebSocketClient) SendMessage(message map[string]interface{}) error {
 	c.mutex.Lock()         // 在写操作之前锁定
 	defer c.mutex.Unlock() // 确保在函数返回时解锁
 
-	msgBytes, err := json.Marshal(message)
-	if err != nil {
-		mylog.Println("Error marshalling message:", err)
-		return err
+	if !c.connected {
+		if shouldQueue(message) {
+			c.queue.push(message)
+			return nil
+		}
+		if !c.replaying {
+			return errNotConnected
+		}
 	}
 
-	err = c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	err := c.writeLocked(message)
 	if err != nil {
 		mylog.Println("Error sending message:", err)
-		// if !c.isReconnecting {
-		// 	go c.Reconnect()
-		// }
-		// 发送失败，将消息放入channel
-		go func() {
-			c.sendFailures <- message
-		}()
+		// 关闭连接,由读取协程发起重连
+		c.connected = false
+		c.replaying = false
+		c.conn.Close()
+		if shouldQueue(message) {
+			c.queue.push(message)
+			return nil
+		}
 		return err
 	}
 
 	return nil
 }
 
+// 写入当前连接 调用方需持有mutex
+func (c *WebSocketClient) writeLocked(message map[string]interface{}) error {
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		mylog.Println("Error marshalling message:", err)
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+}
+
 // 处理onebotv11应用端发来的信息
-func (c *WebSocketClient) handleIncomingMessages(ctx context.Context, cancel context.CancelFunc) {
+func (c *WebSocketClient) handleIncomingMessages(conn *websocket.Conn, cancel context.CancelFunc) {
 	for {
-		_, msg, err := c.conn.ReadMessage()
+		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			mylog.Println("WebSocket connection closed:", err)
 			cancel() // 取消心跳 goroutine
-			if !c.isReconnecting {
+			c.mutex.Lock()
+			// 已被新连接替换时不再重连
+			current := c.conn == conn
+			if current {
+				c.connected = false
+				c.replaying = false
+			}
+			c.mutex.Unlock()
+			if current {
 				go c.Reconnect()
 			}
 			return // 退出循环，不再尝试读取消息
@@ -71,51 +113,132 @@ func (c *WebSocketClient) handleIncomingMessages(ctx context.Context, cancel con
 	}
 }
 
-// 断线重连
+// 连接的角色 API连接不推送事件
+func (c *WebSocketClient) Role() string {
+	return c.role
+}
+
+// 断线重连 以指数退避和随机抖动一直重试,直到连接成功或客户端被关闭
 func (client *WebSocketClient) Reconnect() {
 	client.mutex.Lock()
-	if client.isReconnecting {
+	if client.isReconnecting || client.closed {
 		client.mutex.Unlock()
 		return // 如果已经有其他携程在重连了，就直接返回
 	}
-
-	// 暂存旧的 sendFailures channel，不要关闭它
-	oldSendFailures := client.sendFailures
-
 	client.isReconnecting = true
+	client.connected = false
+	client.replaying = false
 	client.mutex.Unlock()
 
-	defer func() {
+	delay := reconnectBaseDelay
+	for attempt := 1; ; attempt++ {
+		// 加入随机抖动 避免多个连接同时重连
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		mylog.Printf("反向ws[%s]将在%v后进行第%d次重连\n", client.urlStr, wait.Round(time.Millisecond), attempt)
+		time.Sleep(wait)
+
 		client.mutex.Lock()
-		client.isReconnecting = false
-		client.mutex.Unlock()
-	}()
-	reconnecttimes := config.GetReconnecTimes()
-	newClient, err := NewWebSocketClient(client.urlStr, client.botID, client.dg, reconnecttimes)
-	if err == nil && newClient != nil {
-		client.mutex.Lock()        // 在替换连接之前锁定
-		oldCancel := client.cancel // 保存旧的取消函数
-		client.conn = newClient.conn
-		client.dg = newClient.dg
-		oldCancel()                      // 停止所有相关的旧协程
-		client.cancel = newClient.cancel // 更新取消函数
+		closed := client.closed
+		if closed {
+			client.isReconnecting = false
+		}
 		client.mutex.Unlock()
-		// 重发失败的消息
-		go newClient.processFailedMessages(oldSendFailures)
-		mylog.Println("Successfully reconnected to WebSocket.")
-		return
+		if closed {
+			return
+		}
+
+		conn, err := client.dial()
+		if err == nil {
+			// 先结束重连状态 新连接随即断开时可以再次重连
+			client.mutex.Lock()
+			client.isReconnecting = false
+			client.mutex.Unlock()
+			client.start(conn)
+			mylog.Println("Successfully reconnected to WebSocket.")
+			return
+		}
+		mylog.Printf("Failed to reconnect to WebSocket[%v]: %v\n", client.urlStr, err)
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// 使用新连接 发送生命周期事件,补发暂存的事件,并启动心跳和读取协程
+func (c *WebSocketClient) start(conn *websocket.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.mutex.Lock()
+	if c.conn != nil && c.conn != conn {
+		c.conn.Close()
+	}
+	if c.cancel != nil {
+		c.cancel() // 停止所有相关的旧协程
 	}
+	c.conn = conn
+	c.cancel = cancel
+	c.replaying = true
+
+	// API连接不接收生命周期和心跳等事件
+	if c.role != callapi.RoleAPI {
+		// Sending initial message similar to your setupB function
+		message := map[string]interface{}{
+			"meta_event_type": "lifecycle",
+			"post_type":       "meta_event",
+			"self_id":         c.botID,
+			"sub_type":        "connect",
+			"time":            int(time.Now().Unix()),
+		}
+
+		mylog.Printf("Message: %+v\n", message)
+
+		if err := c.writeLocked(message); err != nil {
+			// handle error
+			mylog.Printf("Error sending message: %v\n", err)
+		}
+	}
+	c.mutex.Unlock()
+
+	go c.handleIncomingMessages(conn, cancel)
+	c.replayQueue(conn)
 
+	if c.role != callapi.RoleAPI {
+		heartbeatinterval := config.GetHeartBeatInterval()
+		go c.sendHeartbeat(ctx, c.botID, heartbeatinterval)
+	}
 }
 
-// 处理发送失败的消息
-func (client *WebSocketClient) processFailedMessages(failuresChan chan map[string]interface{}) {
-	for failedMessage := range failuresChan {
-		err := client.SendMessage(failedMessage)
-		if err != nil {
-			// 处理重发失败的情况，比如重新放入 channel 或记录日志
-			mylog.Println("Error re-sending message:", err)
+// 按顺序补发断线期间暂存的事件 补发期间的新事件排在队列末尾
+func (c *WebSocketClient) replayQueue(conn *websocket.Conn) {
+	replayed := 0
+	for {
+		c.mutex.Lock()
+		if c.conn != conn {
+			c.mutex.Unlock()
+			return
 		}
+		event, ok := c.queue.front()
+		if !ok {
+			c.connected = true
+			c.replaying = false
+			c.mutex.Unlock()
+			if replayed > 0 {
+				mylog.Printf("反向ws[%s]已补发%d条断线期间的事件\n", c.urlStr, replayed)
+			}
+			return
+		}
+		if err := c.writeLocked(event.Message); err != nil {
+			mylog.Printf("反向ws[%s]补发事件失败: %v\n", c.urlStr, err)
+			// 关闭连接,由读取协程发起重连,事件留在队列中
+			c.replaying = false
+			conn.Close()
+			c.mutex.Unlock()
+			return
+		}
+		c.queue.remove(event)
+		c.mutex.Unlock()
+		replayed++
 	}
 }
 
@@ -129,6 +252,11 @@ func (c *WebSocketClient) recvMessage(msg []byte) {
 		return
 	}
 	mylog.Println("Received from onebotv11 server:", TruncateMessage(message, 800))
+	// Event连接只推送事件
+	if c.role == callapi.RoleEvent {
+		callapi.SendFailedResponse(c, callapi.RetCodeForbidden, "EVENT_ONLY_CONNECTION", "当前连接为Event连接,不接受action调用,请使用Universal或API连接", message.Echo)
+		return
+	}
 	// 调用callapi
 	callapi.CallAPIFromDict(c, c.dg, message)
 }
@@ -149,136 +277,101 @@ func TruncateMessage(message callapi.ActionMessage, maxLength int) string {
 	return fmt.Sprintf("Action: %s, Params: %s, Echo: %v", message.Action, truncatedParams, message.Echo)
 }
 
-// 发送心跳包
+// 发送心跳包 按配置的间隔发送,未连接时跳过
 func (c *WebSocketClient) sendHeartbeat(ctx context.Context, botID uint64, heartbeatinterval int) {
+	interval := time.Duration(heartbeatinterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(time.Duration(heartbeatinterval) * time.Second):
-			message := map[string]interface{}{
-				"post_type":       "meta_event",
-				"meta_event_type": "heartbeat",
-				"time":            int(time.Now().Unix()),
-				"self_id":         botID,
-				"status": map[string]interface{}{
-					"app_enabled":     true,
-					"app_good":        true,
-					"app_initialized": true,
-					"good":            true,
-					"online":          true,
-					"plugins_good":    nil,
-					"stat": map[string]int{
-						"packet_received":   34933,
-						"packet_sent":       8513,
-						"packet_lost":       0,
-						"message_received":  24674,
-						"message_sent":      1663,
-						"disconnect_times":  0,
-						"lost_times":        0,
-						"last_message_time": int(time.Now().Unix()) - 10, // 假设最后一条消息是10秒前收到的
-					},
-				},
-				"interval": 10000, // 以毫秒为单位
-			}
-			c.SendMessage(message)
+		case <-ticker.C:
+			c.SendMessage(handlers.NewHeartbeatEvent(c.dg, botID, interval))
 		}
 	}
 }
 
-// NewWebSocketClient 创建 WebSocketClient 实例，接受 WebSocket URL、botID 和 openapi.OpenAPI 实例
-func NewWebSocketClient(urlStr string, botID uint64, dg *discordgo.Session, maxRetryAttempts int) (*WebSocketClient, error) {
-	addresses := config.GetWsAddress()
-	tokens := config.GetWsToken()
+// NewWebSocketClient 创建 WebSocketClient 实例，接受 WebSocket URL、连接角色、botID 和 discordgo.Session 实例
+// launchAttempts为启动时阻塞尝试连接的次数(launch_reconnect_times),仍失败时转入后台按退避间隔无限重连,
+// 期间的事件进入队列,因此总能返回可用的client
+func NewWebSocketClient(urlStr string, role string, botID uint64, dg *discordgo.Session, launchAttempts int) *WebSocketClient {
+	role = callapi.NormalizeClientRole(role)
+	client := &WebSocketClient{
+		dg:     dg,
+		botID:  botID,
+		urlStr: urlStr,
+		role:   role,
+		queue:  newEventQueue(role + "|" + urlStr),
+	}
+
+	retryCount := 0
+	for {
+		conn, err := client.dial()
+		if err == nil {
+			mylog.Printf("Successfully connected to %s.\n", urlStr) // 输出连接成功提示
+			client.start(conn)
+			return client
+		}
+		retryCount++
+		if retryCount >= launchAttempts {
+			mylog.Printf("Exceeded launch attempts for WebSocket[%v]: %v, 转入后台重连\n", urlStr, err)
+			go client.Reconnect()
+			return client
+		}
+		mylog.Printf("Failed to connect to WebSocket[%v]: %v, retrying in 5 seconds...\n", urlStr, err)
+		time.Sleep(5 * time.Second) // sleep for 5 seconds before retrying
+	}
+}
 
+// 连接到应用端
+func (c *WebSocketClient) dial() (*websocket.Conn, error) {
 	var token string
-	for index, address := range addresses {
-		if address == urlStr && index < len(tokens) {
-			token = tokens[index]
+	for _, connection := range config.GetReverseWsConnections() {
+		if connection.Address == c.urlStr && callapi.NormalizeClientRole(connection.Role) == c.role {
+			token = connection.Token
 			break
 		}
 	}
 
 	// 检查URL中是否有access_token参数
-	mp := getParamsFromURI(urlStr)
+	mp := getParamsFromURI(c.urlStr)
 	if val, ok := mp["access_token"]; ok {
 		token = val
 	}
 
 	headers := http.Header{
 		"User-Agent":    []string{"CQHttp/4.15.0"},
-		"X-Client-Role": []string{"Universal"},
-		"X-Self-ID":     []string{fmt.Sprintf("%d", botID)},
+		"X-Client-Role": []string{c.role},
+		"X-Self-ID":     []string{fmt.Sprintf("%d", c.botID)},
 	}
 
 	if token != "" {
 		headers["Authorization"] = []string{"Token " + token}
 	}
-	mylog.Printf("准备使用token[%s]连接到[%s]\n", token, urlStr)
+	mylog.Printf("准备使用token[%s]连接到[%s] 角色[%s]\n", token, c.urlStr, c.role)
 	dialer := websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment,
 		HandshakeTimeout: 45 * time.Second,
 	}
 
-	var conn *websocket.Conn
-	var err error
-
-	retryCount := 0
-	for {
-		mylog.Println("Dialing URL:", urlStr)
-		conn, _, err = dialer.Dial(urlStr, headers)
-		if err != nil {
-			retryCount++
-			if retryCount > maxRetryAttempts {
-				mylog.Printf("Exceeded maximum retry attempts for WebSocket[%v]: %v\n", urlStr, err)
-				return nil, err
-			}
-			mylog.Printf("Failed to connect to WebSocket[%v]: %v, retrying in 5 seconds...\n", urlStr, err)
-			time.Sleep(5 * time.Second) // sleep for 5 seconds before retrying
-		} else {
-			mylog.Printf("Successfully connected to %s.\n", urlStr) // 输出连接成功提示
-			break                                                   // successfully connected, break the loop
-		}
-	}
-	client := &WebSocketClient{
-		conn:         conn,
-		dg:           dg,
-		botID:        botID,
-		urlStr:       urlStr,
-		sendFailures: make(chan map[string]interface{}, 100),
-	}
-
-	// Sending initial message similar to your setupB function
-	message := map[string]interface{}{
-		"meta_event_type": "lifecycle",
-		"post_type":       "meta_event",
-		"self_id":         botID,
-		"sub_type":        "connect",
-		"time":            int(time.Now().Unix()),
-	}
-
-	mylog.Printf("Message: %+v\n", message)
-
-	err = client.SendMessage(message)
-	if err != nil {
-		// handle error
-		mylog.Printf("Error sending message: %v\n", err)
-	}
-
-	// Starting goroutine for heartbeats and another for listening to messages
-	ctx, cancel := context.WithCancel(context.Background())
-
-	client.cancel = cancel
-	heartbeatinterval := config.GetHeartBeatInterval()
-	go client.sendHeartbeat(ctx, botID, heartbeatinterval)
-	go client.handleIncomingMessages(ctx, cancel)
-
-	return client, nil
+	mylog.Println("Dialing URL:", c.urlStr)
+	conn, _, err := dialer.Dial(c.urlStr, headers)
+	return conn, err
 }
 
 func (ws *WebSocketClient) Close() error {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
+	ws.closed = true
+	ws.connected = false
+	ws.replaying = false
+	if ws.cancel != nil {
+		ws.cancel()
+	}
+	if ws.conn == nil {
+		return nil
+	}
 	return ws.conn.Close()
 }
 